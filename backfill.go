@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/connector"
+)
+
+// runBackfillCommand asks a running connector to start a backfill for a module, for example
+// sensorthings-connector backfill -module foobot1 -from 2018-01-01T00:00:00Z -to 2018-01-02T00:00:00Z
+func runBackfillCommand(args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	cfgFlag := flags.String("config", "config.json", fmt.Sprintf("path to the %s config file", connector.NAME))
+	moduleFlag := flags.String("module", "", "id of the module to backfill")
+	fromFlag := flags.String("from", "", "start of the time range (RFC3339)")
+	toFlag := flags.String("to", "", "end of the time range (RFC3339), defaults to now")
+	streamsFlag := flags.String("streams", "", "comma separated streamIds to backfill, defaults to all streams")
+	flags.Parse(args)
+
+	cfg, err := configuration.GetConfig(*cfgFlag)
+	if err != nil {
+		return fmt.Errorf("config read error: %v", err)
+	}
+
+	request := connector.BackfillRequest{ModuleID: *moduleFlag}
+	if request.From, err = time.Parse(time.RFC3339, *fromFlag); err != nil {
+		return fmt.Errorf("invalid from: %v", err)
+	}
+
+	request.To = time.Now()
+	if len(*toFlag) > 0 {
+		if request.To, err = time.Parse(time.RFC3339, *toFlag); err != nil {
+			return fmt.Errorf("invalid to: %v", err)
+		}
+	}
+
+	if len(*streamsFlag) > 0 {
+		request.Streams = strings.Split(*streamsFlag, ",")
+	}
+
+	host := cfg.Connector.Host
+	if len(host) == 0 || host == "0.0.0.0" {
+		host = "127.0.0.1"
+	}
+
+	b, _ := json.Marshal(request)
+	res, err := http.Post(fmt.Sprintf("http://%s:%v/Modules/Backfill", host, cfg.Connector.Port), "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	response := connector.BackfillRequest{}
+	json.Unmarshal(body, &response)
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("backfill not started: %v", strings.Join(response.Errors, ", "))
+	}
+
+	fmt.Fprintf(os.Stdout, "backfill started for module %s, progress is shown in the backfill status of the module on /Modules\n", request.ModuleID)
+	return nil
+}
@@ -1,17 +1,159 @@
 package configuration
 
+import "fmt"
+
+// Transports which can be used to send observations to a server
+const (
+	TransportHTTP = "http"
+	TransportMQTT = "mqtt"
+)
+
+// Authentication types for a server
+const (
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthOAuth2 = "oauth2"
+)
+
 // Config contains the settings for the connector
 type Config struct {
 	Connector ConnectorConfig `json:"connector"`
+	Servers   []ServerConfig  `json:"servers"`
 	Logging   LoggingConfig   `json:"logging"`
 }
 
+// ServerConfig contains the settings for a SensorThings server, module mappings
+// can reference the server by name or by url
+type ServerConfig struct {
+	Name           string          `json:"name"`
+	URL            string          `json:"url"`
+	TimeoutSeconds int             `json:"timeoutSeconds"`
+	Transport      string          `json:"transport"`
+	MQTT           MQTTConfig      `json:"mqtt"`
+	Delivery       *DeliveryConfig `json:"delivery"`
+	Auth           AuthConfig      `json:"auth"`
+	HTTP           *HTTPConfig     `json:"http"`
+}
+
+// HTTPConfig contains the settings for the HTTP client used to request vendor APIs and
+// to post to servers, proxy can be an url or empty to use the environment settings
+type HTTPConfig struct {
+	TimeoutSeconds               int    `json:"timeoutSeconds"`
+	DialTimeoutSeconds           int    `json:"dialTimeoutSeconds"`
+	TLSHandshakeTimeoutSeconds   int    `json:"tlsHandshakeTimeoutSeconds"`
+	ResponseHeaderTimeoutSeconds int    `json:"responseHeaderTimeoutSeconds"`
+	IdleConnTimeoutSeconds       int    `json:"idleConnTimeoutSeconds"`
+	MaxIdleConnsPerHost          int    `json:"maxIdleConnsPerHost"`
+	MaxConnsPerHost              int    `json:"maxConnsPerHost"`
+	CABundle                     string `json:"caBundle"`
+	ClientCert                   string `json:"clientCert"`
+	ClientKey                    string `json:"clientKey"`
+	InsecureSkipVerify           bool   `json:"insecureSkipVerify"`
+	Proxy                        string `json:"proxy"`
+}
+
+// AuthConfig contains the credentials used when sending data to a server,
+// type can be basic, bearer or oauth2 (client credentials)
+type AuthConfig struct {
+	Type         string   `json:"type"`
+	Username     string   `json:"username"`
+	Password     string   `json:"password"`
+	Token        string   `json:"token"`
+	TokenURL     string   `json:"tokenUrl"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes"`
+}
+
+// DeliveryConfig contains the retry and circuit breaker settings used when
+// delivering messages to a server, MaxRetries is a pointer so 0 can disable retries
+type DeliveryConfig struct {
+	MaxRetries             *int `json:"maxRetries"`
+	InitialBackoffMs       int  `json:"initialBackoffMilliseconds"`
+	MaxBackoffMs           int  `json:"maxBackoffMilliseconds"`
+	BreakerThreshold       int  `json:"breakerThreshold"`
+	BreakerCooldownSeconds int  `json:"breakerCooldownSeconds"`
+	MaxHeld                int  `json:"maxHeld"`
+}
+
+// MQTTConfig contains the settings for publishing observations to the MQTT broker
+// of a SensorThings server
+type MQTTConfig struct {
+	Broker               string `json:"broker"`
+	ClientID             string `json:"clientId"`
+	Username             string `json:"username"`
+	Password             string `json:"password"`
+	QoS                  byte   `json:"qos"`
+	TopicPrefix          string `json:"topicPrefix"`
+	KeepAliveSeconds     int    `json:"keepAliveSeconds"`
+	TimeoutSeconds       int    `json:"timeoutSeconds"`
+	MaxReconnectInterval int    `json:"maxReconnectIntervalSeconds"`
+}
+
 // ConnectorConfig contains the general config information
 type ConnectorConfig struct {
-	Host                  string `json:"host"`
-	Port                  int    `json:"port"`
-	ModulePath            string `json:"modulePath"`
-	StartModulesOnStartup bool   `json:"startModulesOnStartup"`
+	Host                  string           `json:"host"`
+	Port                  int              `json:"port"`
+	ModulePath            string           `json:"modulePath"`
+	StartModulesOnStartup bool             `json:"startModulesOnStartup"`
+	StopTimeoutSeconds    int              `json:"stopTimeoutSeconds"`
+	DataPath              string           `json:"dataPath"`
+	DryRun                bool             `json:"dryRun"`
+	Sink                  SinkConfig       `json:"sink"`
+	Outbox                OutboxConfig     `json:"outbox"`
+	Batching              BatchingConfig   `json:"batching"`
+	Delivery              DeliveryConfig   `json:"delivery"`
+	Pipeline              PipelineConfig   `json:"pipeline"`
+	Validation            ValidationConfig `json:"validation"`
+	CatchUp               CatchUpConfig    `json:"catchUp"`
+	HTTP                  HTTPConfig       `json:"http"`
+}
+
+// ValidationConfig contains the settings for checking the servers, Datastreams and Things
+// used in the module mappings before the modules are started
+type ValidationConfig struct {
+	Enabled       bool `json:"enabled"`
+	RefuseInvalid bool `json:"refuseInvalid"`
+}
+
+// CatchUpConfig contains the settings for backfilling the readings which are missing on the
+// servers when a module is started, the backfill starts at most maxWindowHours ago
+type CatchUpConfig struct {
+	Enabled        bool `json:"enabled"`
+	MaxWindowHours int  `json:"maxWindowHours"`
+	MinGapSeconds  int  `json:"minGapSeconds"`
+}
+
+// PipelineConfig contains the settings for the queue and workers used per server,
+// overflow can be block, drop-oldest or spill
+type PipelineConfig struct {
+	Workers   int    `json:"workers"`
+	QueueSize int    `json:"queueSize"`
+	Overflow  string `json:"overflow"`
+}
+
+// BatchingConfig contains the settings for posting observations in batches using the
+// SensorThings CreateObservations (dataArray) extension
+type BatchingConfig struct {
+	Enabled            bool `json:"enabled"`
+	WindowMilliseconds int  `json:"windowMilliseconds"`
+	MaxSize            int  `json:"maxSize"`
+}
+
+// OutboxConfig contains the settings for the on-disk outbox which keeps
+// observations and locations until they are accepted by the server
+type OutboxConfig struct {
+	Enabled              bool `json:"enabled"`
+	RetryIntervalSeconds int  `json:"retryIntervalSeconds"`
+}
+
+// SinkConfig contains the settings for writing all messages to rotating NDJSON files,
+// path defaults to a sink folder in the data path
+type SinkConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Path      string `json:"path"`
+	MaxSizeMB int    `json:"maxSizeMB"`
+	MaxFiles  int    `json:"maxFiles"`
 }
 
 // LoggingConfig contains logging settings
@@ -35,5 +177,68 @@ type DiscordConfig struct {
 
 // Validate checks if all mandatory params are set in the config
 func (c Config) Validate() error {
+	if err := c.Connector.HTTP.validate(); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	for _, s := range c.Servers {
+		if len(s.URL) == 0 {
+			return fmt.Errorf("server without url found in servers")
+		}
+
+		if len(s.Name) > 0 {
+			if names[s.Name] {
+				return fmt.Errorf("server name %s is used more than once", s.Name)
+			}
+			names[s.Name] = true
+		}
+
+		switch s.Transport {
+		case "", TransportHTTP:
+		case TransportMQTT:
+			if len(s.MQTT.Broker) == 0 {
+				return fmt.Errorf("no mqtt broker configured for server %s", s.URL)
+			}
+			if s.MQTT.QoS > 2 {
+				return fmt.Errorf("invalid mqtt qos %v for server %s", s.MQTT.QoS, s.URL)
+			}
+		default:
+			return fmt.Errorf("unknown transport %s for server %s", s.Transport, s.URL)
+		}
+
+		if s.HTTP != nil {
+			if err := s.HTTP.validate(); err != nil {
+				return fmt.Errorf("%v for server %s", err, s.URL)
+			}
+		}
+
+		switch s.Auth.Type {
+		case "":
+		case AuthBasic:
+			if len(s.Auth.Username) == 0 {
+				return fmt.Errorf("no username configured for basic auth on server %s", s.URL)
+			}
+		case AuthBearer:
+			if len(s.Auth.Token) == 0 {
+				return fmt.Errorf("no token configured for bearer auth on server %s", s.URL)
+			}
+		case AuthOAuth2:
+			if len(s.Auth.TokenURL) == 0 || len(s.Auth.ClientID) == 0 {
+				return fmt.Errorf("no tokenUrl or clientId configured for oauth2 auth on server %s", s.URL)
+			}
+		default:
+			return fmt.Errorf("unknown auth type %s for server %s", s.Auth.Type, s.URL)
+		}
+	}
+
+	return nil
+}
+
+func (h HTTPConfig) validate() error {
+	if (len(h.ClientCert) == 0) != (len(h.ClientKey) == 0) {
+		return fmt.Errorf("clientCert and clientKey should both be set")
+	}
+
 	return nil
 }
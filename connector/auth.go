@@ -0,0 +1,73 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+var (
+	serverClients      = make(map[string]*http.Client, 0)
+	serverClientsMutex = &sync.Mutex{}
+)
+
+// authTransport adds a static Authorization header to every request
+type authTransport struct {
+	auth configuration.AuthConfig
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	switch t.auth.Type {
+	case configuration.AuthBasic:
+		r.SetBasicAuth(t.auth.Username, t.auth.Password)
+	case configuration.AuthBearer:
+		r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", t.auth.Token))
+	}
+
+	return t.base.RoundTrip(r)
+}
+
+// getServerClient returns the HTTP client for a server which takes care of the configured
+// authentication, timeout and http settings, oauth2 tokens are refreshed when expired
+func getServerClient(host string) *http.Client {
+	host = getHostWithSuffix(host)
+
+	serverClientsMutex.Lock()
+	defer serverClientsMutex.Unlock()
+
+	if client, ok := serverClients[host]; ok {
+		return client
+	}
+
+	server := getServer(host)
+	base, timeout := getServerTransport(server)
+	auth := server.Auth
+	var client *http.Client
+	switch auth.Type {
+	case configuration.AuthBasic, configuration.AuthBearer:
+		client = &http.Client{Transport: &authTransport{auth: auth, base: base}}
+	case configuration.AuthOAuth2:
+		cc := &clientcredentials.Config{
+			ClientID:     auth.ClientID,
+			ClientSecret: auth.ClientSecret,
+			TokenURL:     auth.TokenURL,
+			Scopes:       auth.Scopes,
+		}
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: base, Timeout: timeout})
+		client = cc.Client(ctx)
+	default:
+		client = &http.Client{Transport: base}
+	}
+
+	client.Timeout = timeout
+	serverClients[host] = client
+	return client
+}
@@ -0,0 +1,105 @@
+package connector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gost/sensorthings-connector/configuration"
+)
+
+// registerTestServer registers a server with the given settings, the server and its client
+// are removed when the test finished
+func registerTestServer(t *testing.T, server configuration.ServerConfig) {
+	host := getHostWithSuffix(server.URL)
+	initServers([]configuration.ServerConfig{server})
+	t.Cleanup(func() {
+		delete(servers, host)
+		delete(serverNames, server.Name)
+		serverClientsMutex.Lock()
+		delete(serverClients, host)
+		serverClientsMutex.Unlock()
+	})
+}
+
+func TestAuthHeader(t *testing.T) {
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		auth     configuration.AuthConfig
+		expected string
+	}{
+		{"no auth", configuration.AuthConfig{}, ""},
+		{"basic", configuration.AuthConfig{Type: configuration.AuthBasic, Username: "user", Password: "secret"}, "Basic dXNlcjpzZWNyZXQ="},
+		{"bearer", configuration.AuthConfig{Type: configuration.AuthBearer, Token: "token"}, "Bearer token"},
+	}
+
+	for _, test := range tests {
+		host := server.URL + "/" + test.name + "/v1.0/"
+		registerTestServer(t, configuration.ServerConfig{URL: host, Auth: test.auth})
+
+		header = "unset"
+		resp, err := getServerClient(host).Get(host)
+		if err != nil {
+			t.Errorf("%s: request failed: %v", test.name, err)
+			continue
+		}
+
+		resp.Body.Close()
+		if header != test.expected {
+			t.Errorf("%s: expected Authorization %q, got %q", test.name, test.expected, header)
+		}
+	}
+}
+
+func TestAuthOAuth2(t *testing.T) {
+	tokens := 0
+	headers := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" {
+			headers = append(headers, r.Header.Get("Authorization"))
+			return
+		}
+
+		tokens++
+		id, secret, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" || id != "connector" || secret != "secret" || r.FormValue("scope") != "observations" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	host := server.URL + "/v1.0/"
+	registerTestServer(t, configuration.ServerConfig{URL: host, Auth: configuration.AuthConfig{
+		Type:         configuration.AuthOAuth2,
+		TokenURL:     server.URL + "/token",
+		ClientID:     "connector",
+		ClientSecret: "secret",
+		Scopes:       []string{"observations"},
+	}})
+
+	// the token is requested once and reused until it expires
+	for i := 0; i < 2; i++ {
+		resp, err := getServerClient(host).Get(host)
+		if err != nil {
+			t.Fatalf("request %v failed: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+
+	if tokens != 1 {
+		t.Errorf("expected a single token request, got %v", tokens)
+	}
+	if len(headers) != 2 || headers[0] != "Bearer token" || headers[1] != "Bearer token" {
+		t.Errorf("expected the token on every request, got %v", headers)
+	}
+}
@@ -0,0 +1,67 @@
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gost/sensorthings-connector/module"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+// BackfillRequest can be send to a server endpoint to start a backfill for a module
+type BackfillRequest struct {
+	module.BackfillRequest
+	ModuleID string   `json:"moduleId"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// StartBackfill starts a backfill for the module with the given id
+func StartBackfill(moduleID string, request module.BackfillRequest) error {
+	m, ok := getModule(moduleID)
+	if !ok {
+		return fmt.Errorf("unable to find module %s", moduleID)
+	}
+
+	b, ok := (*m).(module.IBackfillModule)
+	if !ok || !b.CanBackfill() {
+		return fmt.Errorf("module %s does not support backfill", moduleID)
+	}
+
+	if (*m).GetConnectorModuleData().GetState() == module.StateFailed {
+		return fmt.Errorf("module %s is in 'failed' state", moduleID)
+	}
+
+	return b.StartBackfill(request)
+}
+
+func backfillHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	request := BackfillRequest{}
+	status := http.StatusAccepted
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &request)
+	}
+
+	if err != nil || len(request.ModuleID) == 0 {
+		err = fmt.Errorf("POST body is not in the right format")
+	} else {
+		err = StartBackfill(request.ModuleID, request.BackfillRequest)
+	}
+
+	if err != nil {
+		status = http.StatusBadRequest
+		request.Errors = []string{err.Error()}
+		log.Errorf("Requested backfill for module with id: %s from REST service, but failed: %v", request.ModuleID, err)
+	} else {
+		log.Infof("Started backfill for module with id: %s from %v to %v", request.ModuleID, request.From, request.To)
+	}
+
+	js, _ := json.Marshal(request)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
@@ -0,0 +1,359 @@
+package connector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBatchWindow  = 1000
+	defaultBatchMaxSize = 100
+	batchErrorResult    = "error"
+)
+
+var batches *batcher
+
+// batchItem is an observation waiting in a batch together with its outbox key
+type batchItem struct {
+	msg module.ObservationMessage
+	key uint64
+}
+
+// dataArrayEntry is the body for one Datastream in a CreateObservations request
+type dataArrayEntry struct {
+	Datastream map[string]interface{} `json:"Datastream"`
+	Components []string               `json:"components"`
+	Count      int                    `json:"dataArray@iot.count"`
+	DataArray  [][]interface{}        `json:"dataArray"`
+}
+
+// batcher buffers observations per server for a short window and posts them in a single
+// CreateObservations request, servers not supporting the dataArray extension are
+// remembered and receive single posts from then on
+type batcher struct {
+	window      time.Duration
+	maxSize     int
+	mutex       *sync.Mutex
+	pending     map[string][]batchItem
+	timers      map[string]*time.Timer
+	unsupported map[string]bool
+}
+
+// startBatching creates the batcher when batching is enabled in the config
+func startBatching(config configuration.BatchingConfig) {
+	if !config.Enabled {
+		return
+	}
+
+	window := config.WindowMilliseconds
+	if window <= 0 {
+		window = defaultBatchWindow
+	}
+
+	maxSize := config.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultBatchMaxSize
+	}
+
+	log.Infof("Batching observations per server every %vms with a maximum of %v observations", window, maxSize)
+	batches = &batcher{
+		window:      time.Millisecond * time.Duration(window),
+		maxSize:     maxSize,
+		mutex:       &sync.Mutex{},
+		pending:     make(map[string][]batchItem),
+		timers:      make(map[string]*time.Timer),
+		unsupported: make(map[string]bool),
+	}
+}
+
+// stopBatching posts all observations still waiting in a batch
+func stopBatching() {
+	if batches == nil {
+		return
+	}
+
+	batches.mutex.Lock()
+	hosts := make([]string, 0)
+	for host := range batches.pending {
+		hosts = append(hosts, host)
+	}
+	batches.mutex.Unlock()
+
+	for _, host := range hosts {
+		batches.flush(host)
+	}
+}
+
+// add puts an observation in the batch of its server, returns false when the
+// observation cannot be send using a batch
+func (b *batcher) add(msg module.ObservationMessage, key uint64) bool {
+	if msg.Observation.FeatureOfInterest != nil || isMQTTServer(msg.Host) {
+		return false
+	}
+
+	host := getHostWithSuffix(msg.Host)
+
+	b.mutex.Lock()
+	if b.unsupported[host] {
+		b.mutex.Unlock()
+		return false
+	}
+
+	b.pending[host] = append(b.pending[host], batchItem{msg: msg, key: key})
+	full := len(b.pending[host]) >= b.maxSize
+	if _, ok := b.timers[host]; !ok && !full {
+		b.schedule(host)
+	}
+	b.mutex.Unlock()
+
+	if full {
+		b.flush(host)
+	}
+
+	return true
+}
+
+// schedule flushes the batch of a server after the batch window, the batch is posted by a
+// worker of the queue of the server like single observations. b.mutex should be locked
+func (b *batcher) schedule(host string) {
+	b.timers[host] = time.AfterFunc(b.window, func() {
+		getQueue(host).push(job{
+			run: func() { b.flush(host) },
+			drop: func(err error) {
+				b.mutex.Lock()
+				b.schedule(host)
+				b.mutex.Unlock()
+			},
+		})
+	})
+}
+
+// flush posts all waiting observations for a server
+func (b *batcher) flush(host string) {
+	b.mutex.Lock()
+	items := b.pending[host]
+	delete(b.pending, host)
+	if t, ok := b.timers[host]; ok {
+		t.Stop()
+		delete(b.timers, host)
+	}
+	b.mutex.Unlock()
+
+	if len(items) == 0 {
+		return
+	}
+
+	entries, ordered := createDataArray(items)
+	var results []string
+	resp, err := deliver(host, func() (*http.Response, error) {
+		var r *http.Response
+		var e error
+		r, results, e = postDataArray(host, entries)
+		return r, e
+	})
+	if err == nil && len(results) != len(ordered) {
+		err = fmt.Errorf("CreateObservations returned %v results for %v observations", len(results), len(ordered))
+	}
+
+	if err != nil && isUnsupportedBatch(resp) {
+		log.Warnf("Server %s does not support CreateObservations, falling back to single posts", host)
+		b.mutex.Lock()
+		b.unsupported[host] = true
+		b.mutex.Unlock()
+
+		for _, item := range ordered {
+			deliverObservation(item.msg, item.key)
+		}
+		return
+	}
+
+	// a rejected request can be caused by a single invalid observation, posting the observations
+	// one by one only fails the observations which are rejected by the server
+	if err != nil && isPermanentFailure(resp) {
+		for _, item := range ordered {
+			deliverObservation(item.msg, item.key)
+		}
+		return
+	}
+
+	if isBreakerOpen(err) {
+		for _, item := range ordered {
+			msg := item.msg
+			if !holdMessage(host, item.key, err, msg.Status, func() { deliverObservation(msg, 0) }) {
+				resolveMessage(item.key, nil, err, false, msg.Status)
+			}
+		}
+		return
+	}
+
+	for i, item := range ordered {
+		itemErr := err
+		permanent := false
+		if err == nil && results[i] == batchErrorResult {
+			itemErr = fmt.Errorf("server rejected observation for Datastream(%s) in CreateObservations request", item.msg.DatastreamID)
+			permanent = true
+		}
+
+		resolveMessage(item.key, nil, itemErr, permanent, item.msg.Status)
+	}
+}
+
+// createDataArray groups the observations by Datastream, the returned items are
+// ordered the same as the rows in the dataArray entries
+func createDataArray(items []batchItem) ([]dataArrayEntry, []batchItem) {
+	grouped := make(map[string][]batchItem)
+	order := make([]string, 0)
+	for _, item := range items {
+		if _, ok := grouped[item.msg.DatastreamID]; !ok {
+			order = append(order, item.msg.DatastreamID)
+		}
+		grouped[item.msg.DatastreamID] = append(grouped[item.msg.DatastreamID], item)
+	}
+
+	entries := make([]dataArrayEntry, 0)
+	ordered := make([]batchItem, 0)
+	for _, id := range order {
+		group := grouped[id]
+		components := dataArrayComponents(group)
+		entry := dataArrayEntry{
+			Datastream: map[string]interface{}{"@iot.id": toEntityID(id)},
+			Components: components,
+			Count:      len(group),
+			DataArray:  make([][]interface{}, 0),
+		}
+
+		for _, item := range group {
+			entry.DataArray = append(entry.DataArray, dataArrayRow(item.msg.Observation, components))
+			ordered = append(ordered, item)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, ordered
+}
+
+// dataArrayComponents returns the observation properties that are set in at least one of the observations
+func dataArrayComponents(items []batchItem) []string {
+	components := []string{"phenomenonTime", "result"}
+	var resultTime, resultQuality, validTime, parameters bool
+	for _, item := range items {
+		o := item.msg.Observation
+		resultTime = resultTime || o.ResultTime != nil
+		resultQuality = resultQuality || len(o.ResultQuality) > 0
+		validTime = validTime || len(o.ValidTime) > 0
+		parameters = parameters || len(o.Parameters) > 0
+	}
+
+	if resultTime {
+		components = append(components, "resultTime")
+	}
+	if resultQuality {
+		components = append(components, "resultQuality")
+	}
+	if validTime {
+		components = append(components, "validTime")
+	}
+	if parameters {
+		components = append(components, "parameters")
+	}
+
+	return components
+}
+
+func dataArrayRow(o module.Observation, components []string) []interface{} {
+	row := make([]interface{}, 0)
+	for _, c := range components {
+		switch c {
+		case "phenomenonTime":
+			row = append(row, emptyToNil(o.PhenomenonTime))
+		case "result":
+			row = append(row, o.Result)
+		case "resultTime":
+			if o.ResultTime != nil {
+				row = append(row, *o.ResultTime)
+			} else {
+				row = append(row, nil)
+			}
+		case "resultQuality":
+			row = append(row, emptyToNil(o.ResultQuality))
+		case "validTime":
+			row = append(row, emptyToNil(o.ValidTime))
+		case "parameters":
+			row = append(row, o.Parameters)
+		}
+	}
+
+	return row
+}
+
+// postDataArray posts the entries to the CreateObservations endpoint of a server and
+// returns the result for every posted observation, a self link or "error"
+func postDataArray(host string, entries []dataArrayEntry) (*http.Response, []string, error) {
+	b, _ := json.Marshal(entries)
+	req, _ := http.NewRequest("POST", constructCreateObservationsURL(host), bytes.NewBuffer(b))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := getServerClient(host).Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return resp, nil, fmt.Errorf("Unexpected StatusCode, expected %v got %v", http.StatusCreated, resp.StatusCode)
+	}
+
+	results := make([]string, 0)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, err
+	}
+
+	err = json.Unmarshal(body, &results)
+	if err != nil {
+		return resp, nil, fmt.Errorf("unable to parse CreateObservations response: %v", err)
+	}
+
+	return resp, results, nil
+}
+
+// isUnsupportedBatch returns true when the response indicates the server has no CreateObservations endpoint
+func isUnsupportedBatch(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+
+	return resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented
+}
+
+func constructCreateObservationsURL(host string) string {
+	return fmt.Sprintf("%sCreateObservations", getHostWithSuffix(host))
+}
+
+// toEntityID returns numeric ids as number and all others as string
+func toEntityID(id string) interface{} {
+	if i, err := strconv.ParseInt(id, 10, 64); err == nil {
+		return i
+	}
+
+	return id
+}
+
+func emptyToNil(s string) interface{} {
+	if len(s) == 0 {
+		return nil
+	}
+
+	return s
+}
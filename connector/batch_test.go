@@ -0,0 +1,204 @@
+package connector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+)
+
+func newTestBatcher(window time.Duration) *batcher {
+	return &batcher{
+		window:      window,
+		maxSize:     100,
+		mutex:       &sync.Mutex{},
+		pending:     make(map[string][]batchItem),
+		timers:      make(map[string]*time.Timer),
+		unsupported: make(map[string]bool),
+	}
+}
+
+// newBatchServer starts a server which answers CreateObservations requests with the given
+// status and accepts single observations except for Datastream 2
+func newBatchServer(t *testing.T, batchStatus int) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "CreateObservations"):
+			w.WriteHeader(batchStatus)
+			w.Write([]byte(`["http://server/v1.0/Observations(1)"]`))
+		case strings.Contains(r.URL.Path, "Datastreams(2)"):
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	host := server.URL + "/v1.0/"
+	breakersMutex.Lock()
+	breakers[host] = newBreaker(host, configuration.DeliveryConfig{InitialBackoffMs: 1, MaxBackoffMs: 1, BreakerThreshold: 100})
+	breakersMutex.Unlock()
+	return host
+}
+
+func TestCreateDataArray(t *testing.T) {
+	resultTime := "2020-01-01T00:00:05Z"
+	item := func(key uint64, datastreamID string, o module.Observation) batchItem {
+		return batchItem{msg: module.ObservationMessage{DatastreamID: datastreamID, Observation: o}, key: key}
+	}
+
+	tests := []struct {
+		name       string
+		items      []batchItem
+		datastream []interface{}
+		components [][]string
+		rows       [][][]interface{}
+		order      []uint64
+	}{
+		{
+			name:       "single datastream",
+			items:      []batchItem{item(1, "1", module.Observation{PhenomenonTime: "t1", Result: 1}), item(2, "1", module.Observation{PhenomenonTime: "t2", Result: 2})},
+			datastream: []interface{}{int64(1)},
+			components: [][]string{{"phenomenonTime", "result"}},
+			rows:       [][][]interface{}{{{"t1", 1}, {"t2", 2}}},
+			order:      []uint64{1, 2},
+		},
+		{
+			name:       "grouped by datastream in order of appearance",
+			items:      []batchItem{item(1, "b", module.Observation{PhenomenonTime: "t1", Result: 1}), item(2, "1", module.Observation{PhenomenonTime: "t2", Result: 2}), item(3, "b", module.Observation{PhenomenonTime: "t3", Result: 3})},
+			datastream: []interface{}{"b", int64(1)},
+			components: [][]string{{"phenomenonTime", "result"}, {"phenomenonTime", "result"}},
+			rows:       [][][]interface{}{{{"t1", 1}, {"t3", 3}}, {{"t2", 2}}},
+			order:      []uint64{1, 3, 2},
+		},
+		{
+			name:       "optional components",
+			items:      []batchItem{item(1, "1", module.Observation{Result: 1, ResultTime: &resultTime}), item(2, "1", module.Observation{PhenomenonTime: "t2", Result: 2, ResultQuality: "suspect"})},
+			datastream: []interface{}{int64(1)},
+			components: [][]string{{"phenomenonTime", "result", "resultTime", "resultQuality"}},
+			rows:       [][][]interface{}{{{nil, 1, resultTime, nil}, {"t2", 2, nil, "suspect"}}},
+			order:      []uint64{1, 2},
+		},
+	}
+
+	for _, test := range tests {
+		entries, ordered := createDataArray(test.items)
+		if len(entries) != len(test.datastream) {
+			t.Fatalf("%s: expected %v entries, got %v", test.name, len(test.datastream), len(entries))
+		}
+
+		for i, entry := range entries {
+			if entry.Datastream["@iot.id"] != test.datastream[i] {
+				t.Errorf("%s: expected Datastream %v, got %v", test.name, test.datastream[i], entry.Datastream["@iot.id"])
+			}
+			if !reflect.DeepEqual(entry.Components, test.components[i]) {
+				t.Errorf("%s: expected components %v, got %v", test.name, test.components[i], entry.Components)
+			}
+			if entry.Count != len(test.rows[i]) {
+				t.Errorf("%s: expected count %v, got %v", test.name, len(test.rows[i]), entry.Count)
+			}
+			if !reflect.DeepEqual(entry.DataArray, test.rows[i]) {
+				t.Errorf("%s: expected dataArray %v, got %v", test.name, test.rows[i], entry.DataArray)
+			}
+		}
+
+		keys := make([]uint64, 0)
+		for _, item := range ordered {
+			keys = append(keys, item.key)
+		}
+		if !reflect.DeepEqual(keys, test.order) {
+			t.Errorf("%s: expected order %v, got %v", test.name, test.order, keys)
+		}
+	}
+}
+
+func TestDataArrayRow(t *testing.T) {
+	resultTime := "2020-01-01T00:00:05Z"
+	parameters := map[string]interface{}{"a": 1}
+	tests := []struct {
+		name        string
+		observation module.Observation
+		components  []string
+		row         []interface{}
+	}{
+		{"empty phenomenonTime", module.Observation{Result: 1}, []string{"phenomenonTime", "result"}, []interface{}{nil, 1}},
+		{"all components", module.Observation{PhenomenonTime: "t", Result: "x", ResultTime: &resultTime, ResultQuality: "good", ValidTime: "v", Parameters: parameters},
+			[]string{"phenomenonTime", "result", "resultTime", "resultQuality", "validTime", "parameters"},
+			[]interface{}{"t", "x", resultTime, "good", "v", parameters}},
+		{"missing optional values", module.Observation{PhenomenonTime: "t", Result: 2},
+			[]string{"phenomenonTime", "result", "resultTime", "resultQuality", "validTime"},
+			[]interface{}{"t", 2, nil, nil, nil}},
+	}
+
+	for _, test := range tests {
+		if row := dataArrayRow(test.observation, test.components); !reflect.DeepEqual(row, test.row) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.row, row)
+		}
+	}
+}
+
+func TestBatchFlushRejected(t *testing.T) {
+	box = nil
+	host := newBatchServer(t, http.StatusBadRequest)
+	b := newTestBatcher(time.Hour)
+
+	results := make(map[string]error)
+	for _, id := range []string{"1", "2", "3"} {
+		datastreamID := id
+		b.add(module.ObservationMessage{Host: host, DatastreamID: datastreamID, Observation: module.Observation{Result: 1}, Status: func(resp *http.Response, err error) {
+			results[datastreamID] = err
+		}}, 0)
+	}
+
+	// the observations of a rejected batch are posted one by one so only the invalid one fails
+	b.flush(host)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %v", results)
+	}
+	if results["1"] != nil || results["3"] != nil {
+		t.Errorf("expected valid observations to be posted, got %v", results)
+	}
+	if results["2"] == nil {
+		t.Errorf("expected the invalid observation to fail")
+	}
+	if b.unsupported[host] {
+		t.Errorf("expected batching to stay enabled for the server")
+	}
+}
+
+func TestBatchFlushWindow(t *testing.T) {
+	box = nil
+	initQueues(configuration.PipelineConfig{Workers: 1})
+	host := newBatchServer(t, http.StatusCreated)
+	b := newTestBatcher(time.Millisecond * 10)
+
+	done := make(chan error, 1)
+	b.add(module.ObservationMessage{Host: host, DatastreamID: "1", Observation: module.Observation{Result: 1}, Status: func(resp *http.Response, err error) {
+		done <- err
+	}}, 0)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected the batch to be posted, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("batch not posted after the window")
+	}
+
+	// the batch is posted by a worker of the queue of the server
+	var processed int64
+	for i := 0; i < 50 && processed == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+		processed = getQueue(host).status().Processed
+	}
+	if processed != 1 {
+		t.Errorf("expected the flush to run in the queue of the server, %v jobs processed", processed)
+	}
+}
@@ -0,0 +1,311 @@
+package connector
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+	log "github.com/sirupsen/logrus"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+const (
+	defaultMaxRetries       = 2
+	defaultInitialBackoffMs = 500
+	defaultMaxBackoffMs     = 10000
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 60
+	defaultMaxHeld          = 10000
+)
+
+var (
+	defaultDelivery = configuration.DeliveryConfig{}
+	breakers        = make(map[string]*breaker, 0)
+	breakersMutex   = &sync.Mutex{}
+)
+
+// ServerStatus contains the delivery state of a server
+type ServerStatus struct {
+	Host                string `json:"host"`
+	Breaker             string `json:"breaker"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	OpenedAt            string `json:"openedAt,omitempty"`
+	Held                int    `json:"held"`
+}
+
+// breakerOpenError is returned when a message is not send because the
+// circuit breaker of the server is open
+type breakerOpenError struct {
+	host string
+}
+
+func (e breakerOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %s is open", module.RedactURL(e.host))
+}
+
+// isBreakerOpen returns true when the error was caused by an open circuit breaker
+func isBreakerOpen(err error) bool {
+	_, ok := err.(breakerOpenError)
+	return ok
+}
+
+// breaker keeps track of the consecutive delivery failures for a server, when the
+// threshold is reached the breaker opens and messages are held until the cooldown
+// passed and a trial message was delivered. The first held message is the trial when
+// no other message is send to the server after the cooldown
+type breaker struct {
+	host     string
+	policy   configuration.DeliveryConfig
+	mutex    *sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool
+	held     []job
+}
+
+// initDelivery sets the default delivery policy for all servers
+func initDelivery(config configuration.DeliveryConfig) {
+	defaultDelivery = config
+}
+
+// getBreaker returns the breaker for a server
+func getBreaker(host string) *breaker {
+	host = getHostWithSuffix(host)
+
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+
+	if b, ok := breakers[host]; ok {
+		return b
+	}
+
+	policy := defaultDelivery
+	if p := getServer(host).Delivery; p != nil {
+		policy = *p
+	}
+
+	b := newBreaker(host, policy)
+	breakers[host] = b
+	return b
+}
+
+// newBreaker creates a closed breaker for a server, the defaults are used for the
+// settings which are not set in the policy
+func newBreaker(host string, policy configuration.DeliveryConfig) *breaker {
+	return &breaker{
+		host:   host,
+		policy: withDeliveryDefaults(policy),
+		mutex:  &sync.Mutex{},
+		state:  BreakerClosed,
+		held:   make([]job, 0),
+	}
+}
+
+// GetServerStatus returns the delivery state for all servers the connector has send data to
+func GetServerStatus() []ServerStatus {
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+
+	status := make([]ServerStatus, 0)
+	for _, b := range breakers {
+		status = append(status, b.status())
+	}
+
+	return status
+}
+
+// deliver calls send until it succeeds or the maximum number of retries is reached, the
+// time between the retries grows exponentially with some jitter. Nothing is send when
+// the circuit breaker for the host is open
+func deliver(host string, send func() (*http.Response, error)) (*http.Response, error) {
+	b := getBreaker(host)
+	if !b.allow() {
+		return nil, breakerOpenError{host: b.host}
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		resp, err = send()
+		if err == nil || isPermanentFailure(resp) {
+			b.success()
+			return resp, err
+		}
+
+		if attempt >= *b.policy.MaxRetries {
+			break
+		}
+
+		time.Sleep(b.backoff(attempt))
+	}
+
+	b.failure()
+	return resp, err
+}
+
+// allow returns true when a message can be send to the server
+func (b *breaker) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < time.Second*time.Duration(b.policy.BreakerCooldownSeconds) {
+			return false
+		}
+
+		b.state = BreakerHalfOpen
+		b.trial = true
+		return true
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+
+		b.trial = true
+		return true
+	}
+
+	return true
+}
+
+// success closes the breaker and replays the held messages, the held messages are
+// added to the queue of the server one after another so the server is not flooded
+func (b *breaker) success() {
+	b.mutex.Lock()
+	wasClosed := b.state == BreakerClosed
+	b.state = BreakerClosed
+	b.failures = 0
+	b.trial = false
+	held := b.held
+	b.held = make([]job, 0)
+	b.mutex.Unlock()
+
+	if !wasClosed {
+		log.Infof("Circuit breaker for %s closed, server is reachable again", module.RedactURL(b.host))
+	}
+
+	if len(held) == 0 {
+		return
+	}
+
+	q := getQueue(b.host)
+	go func() {
+		for _, j := range held {
+			q.push(j)
+		}
+	}()
+}
+
+// failure registers a failed delivery and opens the breaker when the threshold is reached
+func (b *breaker) failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	b.trial = false
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.policy.BreakerThreshold) {
+		if b.state == BreakerClosed {
+			log.Warnf("Circuit breaker for %s opened after %v consecutive failures, holding messages for %v seconds", module.RedactURL(b.host), b.failures, b.policy.BreakerCooldownSeconds)
+		}
+
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		time.AfterFunc(time.Second*time.Duration(b.policy.BreakerCooldownSeconds), b.probe)
+	}
+}
+
+// probe replays the first held message when the cooldown passed, it is send as the trial
+// of the half-open breaker so the held messages do not wait for a new message to the server
+func (b *breaker) probe() {
+	b.mutex.Lock()
+	if b.state != BreakerOpen || len(b.held) == 0 {
+		b.mutex.Unlock()
+		return
+	}
+
+	j := b.held[0]
+	b.held = b.held[1:]
+	b.mutex.Unlock()
+
+	getQueue(b.host).push(j)
+}
+
+// hold keeps a message in memory until the breaker closes, returns false when
+// the maximum number of held messages is reached
+func (b *breaker) hold(j job) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.held) >= b.policy.MaxHeld {
+		return false
+	}
+
+	b.held = append(b.held, j)
+	return true
+}
+
+// backoff returns the time to wait before the next attempt
+func (b *breaker) backoff(attempt int) time.Duration {
+	d := time.Millisecond * time.Duration(b.policy.InitialBackoffMs) * time.Duration(1<<uint(attempt))
+	max := time.Millisecond * time.Duration(b.policy.MaxBackoffMs)
+	if d > max || d <= 0 {
+		d = max
+	}
+
+	// add up to 50% jitter
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (b *breaker) status() ServerStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := ServerStatus{
+		Host:                module.RedactURL(b.host),
+		Breaker:             b.state,
+		ConsecutiveFailures: b.failures,
+		Held:                len(b.held),
+	}
+
+	if b.state != BreakerClosed {
+		s.OpenedAt = b.openedAt.UTC().String()
+	}
+
+	return s
+}
+
+func withDeliveryDefaults(policy configuration.DeliveryConfig) configuration.DeliveryConfig {
+	if policy.MaxRetries == nil || *policy.MaxRetries < 0 {
+		retries := defaultMaxRetries
+		policy.MaxRetries = &retries
+	}
+	if policy.InitialBackoffMs <= 0 {
+		policy.InitialBackoffMs = defaultInitialBackoffMs
+	}
+	if policy.MaxBackoffMs <= 0 {
+		policy.MaxBackoffMs = defaultMaxBackoffMs
+	}
+	if policy.BreakerThreshold <= 0 {
+		policy.BreakerThreshold = defaultBreakerThreshold
+	}
+	if policy.BreakerCooldownSeconds <= 0 {
+		policy.BreakerCooldownSeconds = defaultBreakerCooldown
+	}
+	if policy.MaxHeld <= 0 {
+		policy.MaxHeld = defaultMaxHeld
+	}
+
+	return policy
+}
@@ -0,0 +1,248 @@
+package connector
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+)
+
+func TestBreakerAllow(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		openedAt time.Duration
+		trial    bool
+		allow    bool
+		after    string
+	}{
+		{"closed", BreakerClosed, 0, false, true, BreakerClosed},
+		{"open within cooldown", BreakerOpen, time.Second, false, false, BreakerOpen},
+		{"open after cooldown", BreakerOpen, time.Minute * 2, false, true, BreakerHalfOpen},
+		{"half-open with running trial", BreakerHalfOpen, time.Minute * 2, true, false, BreakerHalfOpen},
+		{"half-open without trial", BreakerHalfOpen, time.Minute * 2, false, true, BreakerHalfOpen},
+	}
+
+	for _, test := range tests {
+		b := newBreaker("http://allow/", configuration.DeliveryConfig{BreakerCooldownSeconds: 60})
+		b.state = test.state
+		b.openedAt = time.Now().Add(-test.openedAt)
+		b.trial = test.trial
+
+		if got := b.allow(); got != test.allow {
+			t.Errorf("%s: expected allow %v, got %v", test.name, test.allow, got)
+		}
+		if b.state != test.after {
+			t.Errorf("%s: expected state %s, got %s", test.name, test.after, b.state)
+		}
+	}
+}
+
+func TestBreakerFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		failures int
+		after    string
+	}{
+		{"closed below threshold", BreakerClosed, 1, BreakerClosed},
+		{"closed reaching threshold", BreakerClosed, 2, BreakerOpen},
+		{"failed trial", BreakerHalfOpen, 0, BreakerOpen},
+		{"open", BreakerOpen, 5, BreakerOpen},
+	}
+
+	for _, test := range tests {
+		b := newBreaker("http://failure/", configuration.DeliveryConfig{BreakerThreshold: 3})
+		b.state = test.state
+		b.failures = test.failures
+		b.trial = true
+
+		b.failure()
+		if b.state != test.after {
+			t.Errorf("%s: expected state %s, got %s", test.name, test.after, b.state)
+		}
+		if b.failures != test.failures+1 {
+			t.Errorf("%s: expected %v failures, got %v", test.name, test.failures+1, b.failures)
+		}
+		if b.trial {
+			t.Errorf("%s: trial not reset", test.name)
+		}
+	}
+}
+
+func TestBreakerSuccess(t *testing.T) {
+	initQueues(configuration.PipelineConfig{Workers: 1})
+
+	tests := []struct {
+		name     string
+		state    string
+		hold     int
+		expected int
+	}{
+		{"closed", BreakerClosed, 0, 0},
+		{"half-open", BreakerHalfOpen, 0, 0},
+		{"half-open with held messages", BreakerHalfOpen, 3, 2},
+	}
+
+	for _, test := range tests {
+		b := newBreaker("http://success/", configuration.DeliveryConfig{MaxHeld: 2})
+		b.state = test.state
+		b.failures = 4
+		b.trial = true
+
+		ran := make(chan int, test.hold)
+		held := 0
+		for i := 0; i < test.hold; i++ {
+			n := i
+			if b.hold(job{run: func() { ran <- n }}) {
+				held++
+			}
+		}
+		if held != test.expected {
+			t.Errorf("%s: expected %v held messages, got %v", test.name, test.expected, held)
+		}
+
+		b.success()
+		if b.state != BreakerClosed || b.failures != 0 || b.trial || len(b.held) != 0 {
+			t.Errorf("%s: breaker not reset: %+v", test.name, b.status())
+		}
+
+		// held messages are replayed in order by the queue of the server
+		for i := 0; i < held; i++ {
+			select {
+			case n := <-ran:
+				if n != i {
+					t.Errorf("%s: expected held message %v, got %v", test.name, i, n)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: held message %v not replayed", test.name, i)
+			}
+		}
+	}
+}
+
+func TestBreakerBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, time.Millisecond * 100},
+		{1, time.Millisecond * 200},
+		{2, time.Millisecond * 400},
+		{3, time.Millisecond * 500},
+		{62, time.Millisecond * 500},
+	}
+
+	b := newBreaker("http://backoff/", configuration.DeliveryConfig{InitialBackoffMs: 100, MaxBackoffMs: 500})
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			d := b.backoff(test.attempt)
+			if d < test.max/2 || d > test.max {
+				t.Errorf("attempt %v: expected backoff between %v and %v, got %v", test.attempt, test.max/2, test.max, d)
+			}
+		}
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	retries := func(n int) *int { return &n }
+
+	tests := []struct {
+		name       string
+		maxRetries *int
+		attempts   int
+	}{
+		{"default", nil, 3},
+		{"disabled", retries(0), 1},
+		{"single retry", retries(1), 2},
+		{"negative", retries(-1), 3},
+	}
+
+	for _, test := range tests {
+		host := "http://retries/" + test.name + "/"
+		breakersMutex.Lock()
+		breakers[host] = newBreaker(host, configuration.DeliveryConfig{MaxRetries: test.maxRetries, InitialBackoffMs: 1, MaxBackoffMs: 1})
+		breakersMutex.Unlock()
+
+		attempts := 0
+		deliver(host, func() (*http.Response, error) {
+			attempts++
+			return nil, fmt.Errorf("unreachable")
+		})
+
+		if attempts != test.attempts {
+			t.Errorf("%s: expected %v attempts, got %v", test.name, test.attempts, attempts)
+		}
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	initQueues(configuration.PipelineConfig{Workers: 1})
+
+	tests := []struct {
+		name     string
+		state    string
+		hold     int
+		replayed int
+	}{
+		{"open with held messages", BreakerOpen, 2, 1},
+		{"open without held messages", BreakerOpen, 0, 0},
+		{"closed", BreakerClosed, 2, 0},
+	}
+
+	for _, test := range tests {
+		b := newBreaker("http://probe/", configuration.DeliveryConfig{})
+		b.state = test.state
+
+		ran := make(chan int, test.hold)
+		for i := 0; i < test.hold; i++ {
+			n := i
+			b.hold(job{run: func() { ran <- n }})
+		}
+
+		// only the first held message is replayed as trial, the others wait for its result
+		b.probe()
+		for i := 0; i < test.replayed; i++ {
+			select {
+			case n := <-ran:
+				if n != i {
+					t.Errorf("%s: expected held message %v, got %v", test.name, i, n)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: held message %v not replayed", test.name, i)
+			}
+		}
+
+		if held := b.status().Held; held != test.hold-test.replayed {
+			t.Errorf("%s: expected %v held messages, got %v", test.name, test.hold-test.replayed, held)
+		}
+	}
+}
+
+func TestHoldMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    uint64
+		held   bool
+		status error
+	}{
+		{"in memory", 0, true, module.HeldError{}},
+		{"in outbox", 1, false, nil},
+	}
+
+	for _, test := range tests {
+		host := "http://hold/" + test.name + "/"
+		var status error
+		held := holdMessage(host, test.key, breakerOpenError{host: host}, func(resp *http.Response, err error) { status = err }, func() {})
+
+		if held != test.held {
+			t.Errorf("%s: expected held %v, got %v", test.name, test.held, held)
+		}
+		if module.IsHeldError(status) != module.IsHeldError(test.status) {
+			t.Errorf("%s: expected status %v, got %v", test.name, test.status, status)
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package connector
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gost/sensorthings-connector/configuration"
 	"github.com/gost/sensorthings-connector/module"
@@ -19,14 +25,34 @@ const (
 var (
 	// Modules holds all loaded modules including their status
 	Modules      = make(map[string]*module.IConnectorModule, 0)
+	modulesMutex = &sync.RWMutex{}
 	observations = make(chan module.ObservationMessage)
 	locations    = make(chan module.LocationMessage)
 	errors       = make(chan module.ErrorMessage)
+	dataDir      string
+	stopTimeout  int
+	catchUp      configuration.CatchUpConfig
 )
 
 // Start the connector
-func Start(config configuration.ConnectorConfig) {
+func Start(cfg configuration.Config) {
 	log.Infof("Starting %s", NAME)
+	config := cfg.Connector
+	dataDir = getDataPath(config)
+	stopTimeout = config.StopTimeoutSeconds
+	catchUp = config.CatchUp
+	initServers(cfg.Servers)
+	if err := initHTTP(config.HTTP, cfg.Servers); err != nil {
+		log.Fatalf("unable to setup http client: %v", err)
+	}
+
+	initDelivery(config.Delivery)
+
+	// open the sink and outbox before anything can be send
+	startSink(config)
+	startOutbox(config)
+	startBatching(config.Batching)
+	initQueues(config.Pipeline)
 
 	// start listening on channels
 	go listenForObservations()
@@ -39,6 +65,11 @@ func Start(config configuration.ConnectorConfig) {
 		modulePath = os.Args[0]
 	}
 	initModules(modulePath)
+	if box != nil {
+		box.updatePendingCounts()
+	}
+
+	validateModules(config.Validation)
 
 	// start the modules
 	if config.StartModulesOnStartup {
@@ -52,13 +83,17 @@ func Start(config configuration.ConnectorConfig) {
 // Stop the connector
 func Stop() {
 	stopModules()
+	stopBatching()
+	stopOutbox()
+	stopSink()
+	stopMQTT()
 }
 
 func initModules(configPath string) {
 	mod := loadModules(configPath, &observations, &locations, &errors)
 	for _, m := range mod {
 		data := (*m).GetConnectorModuleData()
-		if data.Status.Fatal {
+		if data.GetState() == module.StateFailed {
 			log.Errorf("Error loading module %s: %v\n", data.ModuleFileName, data.Status.LastErrors[0])
 		} else {
 			log.Infof("Module %s loaded: %s - %s  ", data.ModuleFileName, (*m).GetName(), (*m).GetDescription())
@@ -70,7 +105,9 @@ func initModules(configPath string) {
 			addIDError = true
 		}
 
+		modulesMutex.Lock()
 		Modules[(*m).GetID()] = m
+		modulesMutex.Unlock()
 
 		if addIDError {
 			errStr := fmt.Errorf("No ID set for module %s, generated ID = %s", data.ModuleFileName, (*m).GetID())
@@ -84,85 +121,317 @@ func initModules(configPath string) {
 	}
 }
 
-func startModules(isStartup bool) {
+// getModule returns a loaded module, Modules is only written while the modules are loaded
+// but can be read from the channel listeners and the outbox at the same time
+func getModule(id string) (*module.IConnectorModule, bool) {
+	modulesMutex.RLock()
+	defer modulesMutex.RUnlock()
+
+	m, ok := Modules[id]
+	return m, ok
+}
+
+// loadedModules returns the loaded modules
+func loadedModules() []*module.IConnectorModule {
+	modulesMutex.RLock()
+	defer modulesMutex.RUnlock()
+
+	mods := make([]*module.IConnectorModule, 0, len(Modules))
 	for _, m := range Modules {
+		mods = append(mods, m)
+	}
+
+	return mods
+}
+
+func startModules(isStartup bool) {
+	for _, m := range loadedModules() {
 		module := m
 		go startModule(module, isStartup)
 	}
 }
 
+// stopModules stops all modules and waits until they are stopped
 func stopModules() {
-	for _, m := range Modules {
-		module := m
-		go stopModule(module)
+	wg := sync.WaitGroup{}
+	for _, m := range loadedModules() {
+		wg.Add(1)
+		go func(m *module.IConnectorModule) {
+			defer wg.Done()
+			stopModule(m, module.StateStopped)
+		}(m)
 	}
+
+	wg.Wait()
 }
 
-func startModule(module *module.IConnectorModule, isStartup bool) error {
-	if (*module).GetConnectorModuleData().Status.Fatal {
-		return fmt.Errorf("module not sarted because it is in 'Fatal' state")
+func startModule(m *module.IConnectorModule, isStartup bool) error {
+	data := (*m).GetConnectorModuleData()
+	var err error
+	data.UpdateState(func(state string) string {
+		switch state {
+		case module.StateFailed:
+			err = fmt.Errorf("module not started because it is in 'failed' state")
+		case module.StateStopping:
+			err = fmt.Errorf("module not started because it is stopping")
+		case module.StateStarting:
+			err = fmt.Errorf("module not started because it is already starting")
+		default:
+			if validation.RefuseInvalid && len(data.Status.ValidationErrors) > 0 {
+				err = fmt.Errorf("module not started because its mappings are invalid")
+			}
+		}
+
+		if err != nil {
+			return state
+		}
+
+		return module.StateStarting
+	})
+	if err != nil {
+		return err
 	}
 
-	error := (*module).Start(isStartup)
-	if error == nil {
-		(*module).GetConnectorModuleData().Status.Running = true
-	} else {
-		(*module).GetConnectorModuleData().Status.Running = false
-		(*module).GetConnectorModuleData().AddError(error)
+	if catchUp.Enabled {
+		prepareCatchUp(m)
 	}
 
-	return error
+	err = (*m).Start(isStartup)
+	next := module.StateRunning
+	if err != nil {
+		next = module.StateStopped
+		data.AddError(err)
+	}
+
+	data.UpdateState(func(state string) string {
+		if state == module.StateFailed {
+			return state
+		}
+
+		return next
+	})
+
+	return err
 }
 
-func stopModule(module *module.IConnectorModule) {
-	(*module).Stop()
-	(*module).GetConnectorModuleData().Status.Running = false
+// prepareCatchUp requests the latest Observation of the Datastreams of the module before it is
+// started, the readings which are missing since then are backfilled when the module is running
+func prepareCatchUp(m *module.IConnectorModule) {
+	b, ok := (*m).(module.IBackfillModule)
+	if !ok {
+		return
+	}
+
+	maxWindow := time.Hour * time.Duration(catchUp.MaxWindowHours)
+	minGap := time.Second * time.Duration(catchUp.MinGapSeconds)
+	if err := b.PrepareCatchUp(maxWindow, minGap); err != nil {
+		(*m).GetConnectorModuleData().AddWarning(fmt.Errorf("catch-up failed: %v", err))
+		log.Warnf("module %s catch-up failed: %v", (*m).GetID(), err)
+	}
+}
+
+// stopModule stops a module, waits for its running fetch and pending posts and
+// sets the state of the module to the given state, a failed module stays failed
+func stopModule(m *module.IConnectorModule, state string) {
+	data := (*m).GetConnectorModuleData()
+	data.UpdateState(func(current string) string {
+		if current == module.StateFailed {
+			return current
+		}
+
+		return module.StateStopping
+	})
+
+	(*m).Stop()
+	data.UpdateState(func(current string) string {
+		if current == module.StateFailed {
+			return current
+		}
+
+		return state
+	})
 }
 
 func listenForObservations() {
 	for {
 		msg := <-observations
-		go sendObservation(msg)
+		msg.Host = resolveServer(msg.Host)
+		if sink != nil {
+			sink.writeObservation(msg)
+		}
+
+		if dryRun {
+			msg.Status(nil, nil)
+			continue
+		}
+
+		var key uint64
+		if box != nil {
+			key = box.addObservation(msg)
+		}
+
+		getQueue(msg.Host).push(job{
+			key:  key,
+			run:  func() { sendObservation(msg, key) },
+			drop: func(err error) { resolveMessage(key, nil, err, false, msg.Status) },
+		})
 	}
 }
 
 func listenForLocations() {
 	for {
 		msg := <-locations
-		go sendLocation(msg)
+		msg.Host = resolveServer(msg.Host)
+		if sink != nil {
+			sink.writeLocation(msg)
+		}
+
+		if dryRun {
+			msg.Status(nil, nil)
+			continue
+		}
+
+		var key uint64
+		if box != nil {
+			key = box.addLocation(msg)
+		}
+
+		getQueue(msg.Host).push(job{
+			key:  key,
+			run:  func() { deliverLocation(msg, key) },
+			drop: func(err error) { resolveMessage(key, nil, err, false, msg.Status) },
+		})
 	}
 }
 
 func listenForErrors() {
 	for {
 		msg := <-errors
-		m := Modules[msg.ModuleID]
+		m, ok := getModule(msg.ModuleID)
 
-		if m == nil {
+		if !ok {
 			log.Errorf("incoming error from not registered module id %s: %v", msg.ModuleID, msg.Error)
-			return
+			continue
+		}
+
+		if msg.Warning {
+			(*m).GetConnectorModuleData().AddWarning(msg.Error)
+			log.Warnf("module %s warning: %v", (*m).GetConnectorModuleData().ModuleFilePath, msg.Error)
+			continue
 		}
 
 		// Add error to the module and log the error
 		(*m).GetConnectorModuleData().AddError(msg.Error)
 		log.Errorf("module %s error: %v", (*m).GetConnectorModuleData().ModuleFilePath, msg.Error)
 		if msg.Fatal {
-			status := (*m).GetConnectorModuleData().Status
-			status.Fatal = true
-			status.Running = false
-			(*m).Stop()
+			(*m).GetConnectorModuleData().SetState(module.StateFailed)
+			go stopModule(m, module.StateFailed)
 		}
 	}
 }
 
-func sendObservation(msg module.ObservationMessage) {
-	b, err := module.PostJSON(constructObservationURL(msg.Host, msg.DatastreamID), msg.Observation, 201)
-	msg.Status(b, err)
+func sendObservation(msg module.ObservationMessage, key uint64) {
+	if batches != nil && batches.add(msg, key) {
+		return
+	}
+
+	deliverObservation(msg, key)
 }
 
-func sendLocation(msg module.LocationMessage) {
-	b, err := module.PostJSON(constructLocationURL(msg.Host, msg.ThingID), msg.Location, 201)
-	msg.Status(b, err)
+// deliverObservation posts a single observation and releases it from the outbox
+func deliverObservation(msg module.ObservationMessage, key uint64) {
+	b, err := postObservation(msg.Host, msg.DatastreamID, msg.Observation)
+	if isBreakerOpen(err) && holdMessage(msg.Host, key, err, msg.Status, func() { deliverObservation(msg, 0) }) {
+		return
+	}
+
+	resolveMessage(key, b, err, isPermanentFailure(b), msg.Status)
+}
+
+// deliverLocation posts a location and releases it from the outbox
+func deliverLocation(msg module.LocationMessage, key uint64) {
+	b, err := postLocation(msg.Host, msg.ThingID, msg.Location)
+	if isBreakerOpen(err) && holdMessage(msg.Host, key, err, msg.Status, func() { deliverLocation(msg, 0) }) {
+		return
+	}
+
+	resolveMessage(key, b, err, isPermanentFailure(b), msg.Status)
+}
+
+// resolveMessage releases a message from the outbox and calls its status callback, a message
+// which failed but stays in the outbox for a retry is resolved with a module.OutboxError
+func resolveMessage(key uint64, resp *http.Response, err error, permanent bool, status module.PostStatus) {
+	if box == nil || key == 0 {
+		status(resp, err)
+		return
+	}
+
+	box.release(key, err, permanent)
+	if err != nil && !permanent {
+		status(resp, module.OutboxError{Err: err})
+		return
+	}
+
+	status(resp, err)
+}
+
+// holdMessage keeps a message which was not send because the circuit breaker of the server is open in
+// memory until the breaker closes, returns false for messages in the outbox which are retried from there.
+// The status of a held message is called with a module.HeldError so the module does not wait for it
+func holdMessage(host string, key uint64, err error, status module.PostStatus, run func()) bool {
+	if key != 0 {
+		return false
+	}
+
+	if !getBreaker(host).hold(job{run: run, drop: func(err error) { status(nil, err) }}) {
+		return false
+	}
+
+	status(nil, module.HeldError{Err: err})
+	return true
+}
+
+func postObservation(host, datastreamID string, observation module.Observation) (*http.Response, error) {
+	payload, err := observationPayload(host, observation)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliver(host, func() (*http.Response, error) {
+		if server := getServer(host); server.Transport == configuration.TransportMQTT {
+			return nil, publishObservation(server, datastreamID, payload)
+		}
+
+		return module.PostJSONWithClient(getServerClient(host), constructObservationURL(host, datastreamID), payload, 201)
+	})
+}
+
+// observationPayload returns the body to post for an observation, an inline FeatureOfInterest is
+// replaced by a reference to the FeatureOfInterest on the server so it is only created once
+func observationPayload(host string, observation module.Observation) (interface{}, error) {
+	if observation.FeatureOfInterest == nil || len(observation.FeatureOfInterest.Name) == 0 {
+		return observation, nil
+	}
+
+	id, err := sensorThings.provisionFeatureOfInterest(host, *observation.FeatureOfInterest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to provision FeatureOfInterest %s: %v", observation.FeatureOfInterest.Name, err)
+	}
+
+	b, _ := json.Marshal(observation)
+	payload := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	decoder.Decode(&payload)
+	payload["FeatureOfInterest"] = entityRef(id)
+	delete(payload, "featureOfInterest")
+	return payload, nil
+}
+
+func postLocation(host, thingID string, location module.Location) (*http.Response, error) {
+	return deliver(host, func() (*http.Response, error) {
+		return module.PostJSONWithClient(getServerClient(host), constructLocationURL(host, thingID), location, 201)
+	})
 }
 
 func constructObservationURL(host, streamID string) string {
@@ -173,6 +442,15 @@ func constructLocationURL(host, thingID string) string {
 	return fmt.Sprintf("%sThings(%s)/Locations", getHostWithSuffix(host), thingID)
 }
 
+// getDataPath returns the folder where the connector keeps its state
+func getDataPath(config configuration.ConnectorConfig) string {
+	if len(config.DataPath) > 0 {
+		return config.DataPath
+	}
+
+	return filepath.Join(filepath.Dir(os.Args[0]), "data")
+}
+
 func getHostWithSuffix(host string) string {
 	if strings.HasSuffix(host, "/") {
 		return host
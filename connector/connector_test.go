@@ -0,0 +1,127 @@
+package connector
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+)
+
+// lifecycleModule is a module which returns startErr from Start and counts the calls to Stop
+type lifecycleModule struct {
+	module.ConnectorModuleBase
+	startErr error
+	stops    int
+}
+
+func (m *lifecycleModule) Setup() error {
+	return nil
+}
+
+func (m *lifecycleModule) Start(onStartup bool) error {
+	return m.startErr
+}
+
+func (m *lifecycleModule) Stop() {
+	m.stops++
+}
+
+func newLifecycleModule(state string, startErr error) (*module.IConnectorModule, *lifecycleModule) {
+	m := &lifecycleModule{startErr: startErr}
+	m.SetConnectorModuleData(module.NewConnectorModuleData("1", "test.so", "test.so", nil, nil, nil))
+	m.ModuleData.SetState(state)
+
+	var i module.IConnectorModule = m
+	return &i, m
+}
+
+func TestStartModule(t *testing.T) {
+	defer func() { validation = configuration.ValidationConfig{} }()
+
+	tests := []struct {
+		name          string
+		state         string
+		startErr      error
+		refuseInvalid bool
+		expected      string
+		err           bool
+	}{
+		{"stopped", module.StateStopped, nil, false, module.StateRunning, false},
+		{"running", module.StateRunning, nil, false, module.StateRunning, false},
+		{"start error", module.StateStopped, fmt.Errorf("failed"), false, module.StateStopped, true},
+		{"failed", module.StateFailed, nil, false, module.StateFailed, true},
+		{"stopping", module.StateStopping, nil, false, module.StateStopping, true},
+		{"starting", module.StateStarting, nil, false, module.StateStarting, true},
+		{"invalid mappings", module.StateStopped, nil, true, module.StateStopped, true},
+	}
+
+	for _, test := range tests {
+		validation = configuration.ValidationConfig{RefuseInvalid: test.refuseInvalid}
+		m, lm := newLifecycleModule(test.state, test.startErr)
+		lm.ModuleData.Status.ValidationErrors = []string{"stream 1 on gost could not be resolved"}
+
+		err := startModule(m, false)
+		if (err != nil) != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+		if state := lm.ModuleData.GetState(); state != test.expected {
+			t.Errorf("%s: expected state %s, got %s", test.name, test.expected, state)
+		}
+	}
+}
+
+func TestStopModule(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		stopAs   string
+		expected string
+	}{
+		{"running", module.StateRunning, module.StateStopped, module.StateStopped},
+		{"failed", module.StateFailed, module.StateStopped, module.StateFailed},
+		{"stopped as failed", module.StateRunning, module.StateFailed, module.StateFailed},
+	}
+
+	for _, test := range tests {
+		m, lm := newLifecycleModule(test.state, nil)
+		stopModule(m, test.stopAs)
+
+		if lm.stops != 1 {
+			t.Errorf("%s: expected Stop to be called once, got %v", test.name, lm.stops)
+		}
+		if state := lm.ModuleData.GetState(); state != test.expected {
+			t.Errorf("%s: expected state %s, got %s", test.name, test.expected, state)
+		}
+	}
+}
+
+func TestStartBackfillModule(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    string
+		state string
+		err   string
+	}{
+		{"not found", "other", module.StateStopped, "unable to find module other"},
+		{"no Backfiller", "backfill", module.StateRunning, "module backfill does not support backfill"},
+	}
+
+	m, _ := newLifecycleModule(module.StateStopped, nil)
+	modulesMutex.Lock()
+	Modules["backfill"] = m
+	modulesMutex.Unlock()
+	defer func() {
+		modulesMutex.Lock()
+		delete(Modules, "backfill")
+		modulesMutex.Unlock()
+	}()
+
+	for _, test := range tests {
+		(*m).GetConnectorModuleData().SetState(test.state)
+		err := StartBackfill(test.id, module.BackfillRequest{})
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+	}
+}
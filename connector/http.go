@@ -0,0 +1,162 @@
+package connector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+)
+
+const (
+	defaultHTTPTimeout         = 30
+	defaultDialTimeout         = 10
+	defaultTLSHandshakeTimeout = 10
+	defaultIdleConnTimeout     = 90
+	defaultMaxIdleConnsPerHost = 10
+)
+
+var (
+	// httpConfig holds the shared http settings
+	httpConfig = configuration.HTTPConfig{}
+	// transport is shared by all servers without their own http settings
+	transport http.RoundTripper = http.DefaultTransport
+	// serverTransports holds the transport for servers with their own http settings
+	serverTransports = make(map[string]http.RoundTripper, 0)
+)
+
+// initHTTP creates the shared HTTP client which is used by the modules for vendor requests
+// and as base for the server clients, servers with http settings get their own transport
+func initHTTP(config configuration.HTTPConfig, servers []configuration.ServerConfig) error {
+	t, err := newTransport(config)
+	if err != nil {
+		return err
+	}
+
+	httpConfig = config
+	transport = t
+	module.SetHTTPClient(&http.Client{
+		Transport: t,
+		Timeout:   httpTimeout(config),
+	})
+
+	for _, s := range servers {
+		if s.HTTP == nil {
+			continue
+		}
+
+		t, err := newTransport(*s.HTTP)
+		if err != nil {
+			return fmt.Errorf("server %s: %v", module.RedactURL(s.URL), err)
+		}
+
+		serverTransports[getHostWithSuffix(s.URL)] = t
+	}
+
+	return nil
+}
+
+// newTransport creates a pooling transport for the given settings
+func newTransport(config configuration.HTTPConfig) (*http.Transport, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if len(config.CABundle) > 0 {
+		pem, err := ioutil.ReadFile(config.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", config.CABundle)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(config.ClientCert) > 0 {
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %v", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if len(config.Proxy) > 0 {
+		u, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %s: %v", config.Proxy, err)
+		}
+
+		proxy = http.ProxyURL(u)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   seconds(config.DialTimeoutSeconds, defaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+
+	maxIdle := config.MaxIdleConnsPerHost
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdleConnsPerHost
+	}
+
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   seconds(config.TLSHandshakeTimeoutSeconds, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: time.Second * time.Duration(config.ResponseHeaderTimeoutSeconds),
+		IdleConnTimeout:       seconds(config.IdleConnTimeoutSeconds, defaultIdleConnTimeout),
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   maxIdle,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		ForceAttemptHTTP2:     true,
+	}, nil
+}
+
+// getServerTransport returns the transport and timeout for a server
+func getServerTransport(server configuration.ServerConfig) (http.RoundTripper, time.Duration) {
+	t, ok := serverTransports[getHostWithSuffix(server.URL)]
+	if !ok {
+		t = transport
+	}
+
+	timeout := httpTimeout(httpConfig)
+	if server.HTTP != nil {
+		timeout = httpTimeout(*server.HTTP)
+	}
+
+	if server.TimeoutSeconds > 0 {
+		timeout = time.Second * time.Duration(server.TimeoutSeconds)
+	}
+
+	return t, timeout
+}
+
+func httpTimeout(config configuration.HTTPConfig) time.Duration {
+	if config.TimeoutSeconds < 0 {
+		return 0
+	}
+
+	return seconds(config.TimeoutSeconds, defaultHTTPTimeout)
+}
+
+func seconds(value, defaultValue int) time.Duration {
+	if value <= 0 {
+		value = defaultValue
+	}
+
+	return time.Second * time.Duration(value)
+}
@@ -0,0 +1,144 @@
+package connector
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+)
+
+func TestNewTransport(t *testing.T) {
+	dir := t.TempDir()
+	noCerts := filepath.Join(dir, "empty.pem")
+	ioutil.WriteFile(noCerts, []byte("no certificates"), 0644)
+
+	tests := []struct {
+		name      string
+		config    configuration.HTTPConfig
+		proxy     string
+		handshake time.Duration
+		idle      time.Duration
+		maxIdle   int
+		err       bool
+	}{
+		{"defaults", configuration.HTTPConfig{}, "", time.Second * 10, time.Second * 90, 10, false},
+		{"custom", configuration.HTTPConfig{TLSHandshakeTimeoutSeconds: 5, IdleConnTimeoutSeconds: 30, MaxIdleConnsPerHost: 2, Proxy: "http://proxy:3128"}, "http://proxy:3128", time.Second * 5, time.Second * 30, 2, false},
+		{"invalid proxy", configuration.HTTPConfig{Proxy: "://proxy"}, "", 0, 0, 0, true},
+		{"missing CA bundle", configuration.HTTPConfig{CABundle: filepath.Join(dir, "missing.pem")}, "", 0, 0, 0, true},
+		{"CA bundle without certificates", configuration.HTTPConfig{CABundle: noCerts}, "", 0, 0, 0, true},
+		{"missing client certificate", configuration.HTTPConfig{ClientCert: filepath.Join(dir, "cert.pem"), ClientKey: filepath.Join(dir, "key.pem")}, "", 0, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		tr, err := newTransport(test.config)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if tr.TLSHandshakeTimeout != test.handshake || tr.IdleConnTimeout != test.idle || tr.MaxIdleConnsPerHost != test.maxIdle {
+			t.Errorf("%s: unexpected transport settings %v %v %v", test.name, tr.TLSHandshakeTimeout, tr.IdleConnTimeout, tr.MaxIdleConnsPerHost)
+		}
+
+		if len(test.proxy) > 0 {
+			u, _ := tr.Proxy(httptest.NewRequest("GET", "http://gost/v1.0/", nil))
+			if u == nil || u.String() != test.proxy {
+				t.Errorf("%s: expected proxy %s, got %v", test.name, test.proxy, u)
+			}
+		}
+	}
+}
+
+func TestTransportCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+
+	tests := []struct {
+		name   string
+		config configuration.HTTPConfig
+		err    bool
+	}{
+		{"system roots", configuration.HTTPConfig{}, true},
+		{"CA bundle", configuration.HTTPConfig{CABundle: bundle}, false},
+		{"insecure", configuration.HTTPConfig{InsecureSkipVerify: true}, false},
+	}
+
+	for _, test := range tests {
+		tr, err := newTransport(test.config)
+		if err != nil {
+			t.Fatalf("%s: unable to create transport: %v", test.name, err)
+		}
+
+		resp, err := (&http.Client{Transport: tr}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestInitHTTP(t *testing.T) {
+	client := module.HTTPClient()
+	defer func() {
+		httpConfig = configuration.HTTPConfig{}
+		transport = http.DefaultTransport
+		serverTransports = make(map[string]http.RoundTripper, 0)
+		module.SetHTTPClient(client)
+	}()
+
+	own := configuration.ServerConfig{URL: "http://own/v1.0", HTTP: &configuration.HTTPConfig{TimeoutSeconds: 7}}
+	err := initHTTP(configuration.HTTPConfig{TimeoutSeconds: 5}, []configuration.ServerConfig{own})
+	if err != nil {
+		t.Fatalf("unable to init http: %v", err)
+	}
+
+	// modules use the shared client for vendor requests
+	if module.HTTPClient().Timeout != time.Second*5 || module.HTTPClient().Transport != transport {
+		t.Errorf("expected the shared client with a timeout of 5s, got %v", module.HTTPClient().Timeout)
+	}
+
+	tests := []struct {
+		name    string
+		server  configuration.ServerConfig
+		shared  bool
+		timeout time.Duration
+	}{
+		{"shared settings", configuration.ServerConfig{URL: "http://shared/v1.0/"}, true, time.Second * 5},
+		{"own http settings", own, false, time.Second * 7},
+		{"server timeout", configuration.ServerConfig{URL: "http://shared/v1.0/", TimeoutSeconds: 3}, true, time.Second * 3},
+		{"without timeout", configuration.ServerConfig{URL: "http://shared/v1.0/", HTTP: &configuration.HTTPConfig{TimeoutSeconds: -1}}, true, 0},
+	}
+
+	for _, test := range tests {
+		tr, timeout := getServerTransport(test.server)
+		if (tr == transport) != test.shared {
+			t.Errorf("%s: expected shared transport %v", test.name, test.shared)
+		}
+		if timeout != test.timeout {
+			t.Errorf("%s: expected timeout %v, got %v", test.name, test.timeout, timeout)
+		}
+	}
+
+	if err := initHTTP(configuration.HTTPConfig{}, []configuration.ServerConfig{{URL: "http://bad/", HTTP: &configuration.HTTPConfig{Proxy: "://proxy"}}}); err == nil {
+		t.Errorf("expected an error for invalid server http settings")
+	}
+}
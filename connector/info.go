@@ -13,8 +13,10 @@ var moduleInfos = info{}
 // info contains information about the loaded modules which
 // can be returned by the connector HTTP server
 type info struct {
-	ConnectorStarted string       `json:"started"`
-	Modules          []moduleInfo `json:"modules"`
+	ConnectorStarted string         `json:"started"`
+	Modules          []moduleInfo   `json:"modules"`
+	Servers          []ServerStatus `json:"servers"`
+	Queues           []QueueStatus  `json:"queues"`
 }
 
 // moduleInfo contains information about all endpoints for
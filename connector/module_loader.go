@@ -31,6 +31,10 @@ func loadModules(modulePath string, obsChannel *chan module.ObservationMessage,
 		// try loading module
 		loaded, err := tryLoadModule(k, v)
 		d := module.NewConnectorModuleData(VERSION, k, v, obsChannel, locChannel, errorChannel)
+		d.DataPath = dataDir
+		d.StopTimeout = stopTimeout
+		d.Servers = serverNames
+		d.SensorThings = sensorThings
 
 		if err != nil {
 			// Unable to load, create dummy for logging purpose
@@ -53,8 +57,7 @@ func loadModules(modulePath string, obsChannel *chan module.ObservationMessage,
 
 func createDummy(moduleFileName string, d *module.ConnectorModuleData, err error) *module.ConnectorModuleBase {
 	d.AddError(fmt.Errorf("error loading module %s: %v", moduleFileName, err))
-	d.Status.Running = false
-	d.Status.Fatal = true
+	d.SetState(module.StateFailed)
 	dummy := &module.ConnectorModuleBase{}
 	dummy.SetConnectorModuleData(d)
 
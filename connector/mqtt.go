@@ -0,0 +1,162 @@
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMQTTTimeout              = 10
+	defaultMQTTKeepAlive            = 30
+	defaultMQTTMaxReconnectInterval = 60
+)
+
+var (
+	mqttClients = make(map[string]*mqttClient, 0)
+	mqttMutex   = &sync.Mutex{}
+)
+
+// mqttClient is the client for a broker together with the token of its first connect
+type mqttClient struct {
+	client  mqtt.Client
+	connect mqtt.Token
+}
+
+// publishObservation publishes an observation to the MQTT broker of a server
+// using the SensorThings MQTT create topic of the Datastream
+func publishObservation(server configuration.ServerConfig, datastreamID string, observation interface{}) error {
+	client, err := getMQTTClient(server)
+	if err != nil {
+		return err
+	}
+
+	if !client.IsConnectionOpen() {
+		return fmt.Errorf("not connected to mqtt broker %s", server.MQTT.Broker)
+	}
+
+	b, err := json.Marshal(observation)
+	if err != nil {
+		return err
+	}
+
+	token := client.Publish(constructObservationTopic(server, datastreamID), server.MQTT.QoS, false, b)
+	if !token.WaitTimeout(mqttTimeout(server)) {
+		return fmt.Errorf("timeout publishing to mqtt broker %s", server.MQTT.Broker)
+	}
+
+	return token.Error()
+}
+
+// getMQTTClient returns the client for a server, the client is created and connected on first
+// use and keeps reconnecting when the connection is lost. An error is returned when the first
+// connect did not succeed within the timeout
+func getMQTTClient(server configuration.ServerConfig) (mqtt.Client, error) {
+	c := newMQTTClient(server)
+	if !c.connect.WaitTimeout(mqttTimeout(server)) {
+		return nil, fmt.Errorf("timeout connecting to mqtt broker %s", server.MQTT.Broker)
+	}
+
+	if err := c.connect.Error(); err != nil {
+		return nil, fmt.Errorf("unable to connect to mqtt broker %s: %v", server.MQTT.Broker, err)
+	}
+
+	return c.client, nil
+}
+
+// newMQTTClient returns the client for a server and starts connecting when the client is new
+func newMQTTClient(server configuration.ServerConfig) *mqttClient {
+	host := getHostWithSuffix(server.URL)
+
+	mqttMutex.Lock()
+	defer mqttMutex.Unlock()
+
+	if c, ok := mqttClients[host]; ok {
+		return c
+	}
+
+	cfg := server.MQTT
+	clientID := cfg.ClientID
+	if len(clientID) == 0 {
+		clientID = fmt.Sprintf("sensorthings-connector-%s", module.RandomID(8))
+	}
+
+	keepAlive := cfg.KeepAliveSeconds
+	if keepAlive <= 0 {
+		keepAlive = defaultMQTTKeepAlive
+	}
+
+	maxReconnect := cfg.MaxReconnectInterval
+	if maxReconnect <= 0 {
+		maxReconnect = defaultMQTTMaxReconnectInterval
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(clientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetKeepAlive(time.Second * time.Duration(keepAlive)).
+		SetConnectTimeout(mqttTimeout(server)).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(time.Second * time.Duration(maxReconnect)).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			log.Infof("Connected to mqtt broker %s", cfg.Broker)
+		}).
+		SetConnectionLostHandler(func(c mqtt.Client, err error) {
+			log.Errorf("Connection to mqtt broker %s lost: %v", cfg.Broker, err)
+		})
+
+	client := mqtt.NewClient(opts)
+	c := &mqttClient{client: client, connect: client.Connect()}
+	mqttClients[host] = c
+
+	return c
+}
+
+// stopMQTT disconnects all mqtt clients
+func stopMQTT() {
+	mqttMutex.Lock()
+	defer mqttMutex.Unlock()
+
+	for host, c := range mqttClients {
+		c.client.Disconnect(250)
+		delete(mqttClients, host)
+	}
+}
+
+func mqttTimeout(server configuration.ServerConfig) time.Duration {
+	timeout := server.MQTT.TimeoutSeconds
+	if timeout <= 0 {
+		timeout = defaultMQTTTimeout
+	}
+
+	return time.Second * time.Duration(timeout)
+}
+
+// constructObservationTopic creates the MQTT create topic for a Datastream, when no
+// prefix is configured the version from the server url is used, for example v1.0
+func constructObservationTopic(server configuration.ServerConfig, datastreamID string) string {
+	prefix := server.MQTT.TopicPrefix
+	if len(prefix) == 0 {
+		if u, err := url.Parse(server.URL); err == nil {
+			prefix = u.Path
+		}
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	if len(prefix) == 0 {
+		return fmt.Sprintf("Datastreams(%s)/Observations", datastreamID)
+	}
+
+	return fmt.Sprintf("%s/Datastreams(%s)/Observations", prefix, datastreamID)
+}
@@ -0,0 +1,171 @@
+package connector
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+)
+
+// testBroker is a minimal in-process MQTT 3.1.1 broker which acknowledges
+// connects and publishes and keeps the published messages
+type testBroker struct {
+	listener  net.Listener
+	mutex     *sync.Mutex
+	published map[string][]byte
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start broker: %v", err)
+	}
+
+	b := &testBroker{listener: l, mutex: &sync.Mutex{}, published: make(map[string][]byte)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() { l.Close() })
+	return b
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *testBroker) message(topic string) ([]byte, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	m, ok := b.published[topic]
+	return m, ok
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+
+		length, multiplier := 0, 1
+		for {
+			d, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			length += int(d&127) * multiplier
+			multiplier *= 128
+			if d&128 == 0 {
+				break
+			}
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT
+			conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: // PUBLISH
+			topicLength := int(body[0])<<8 | int(body[1])
+			topic := string(body[2 : 2+topicLength])
+			payload := body[2+topicLength:]
+			if qos := (header >> 1) & 3; qos > 0 {
+				id := payload[:2]
+				payload = payload[2:]
+				conn.Write([]byte{0x40, 0x02, id[0], id[1]})
+			}
+
+			b.mutex.Lock()
+			b.published[topic] = payload
+			b.mutex.Unlock()
+		case 12: // PINGREQ
+			conn.Write([]byte{0xD0, 0x00})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func TestPublishObservation(t *testing.T) {
+	defer stopMQTT()
+	broker := newTestBroker(t)
+
+	tests := []struct {
+		name  string
+		qos   byte
+		topic string
+	}{
+		{"qos0", 0, "v1.0/Datastreams(1)/Observations"},
+		{"qos1", 1, "custom/Datastreams(1)/Observations"},
+	}
+
+	for _, test := range tests {
+		server := configuration.ServerConfig{
+			URL:       "http://" + test.name + "/v1.0/",
+			Transport: configuration.TransportMQTT,
+			MQTT:      configuration.MQTTConfig{Broker: broker.url(), QoS: test.qos, TimeoutSeconds: 5},
+		}
+		if test.qos > 0 {
+			server.MQTT.TopicPrefix = "custom"
+		}
+
+		// the first publish has to wait for the connection instead of failing
+		err := publishObservation(server, "1", module.Observation{PhenomenonTime: "2020-01-01T00:00:00Z", Result: 1.5})
+		if err != nil {
+			t.Fatalf("%s: first publish failed: %v", test.name, err)
+		}
+
+		var payload []byte
+		for i := 0; i < 50; i++ {
+			var ok bool
+			if payload, ok = broker.message(test.topic); ok {
+				break
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+
+		observation := module.Observation{}
+		if err := json.Unmarshal(payload, &observation); err != nil {
+			t.Fatalf("%s: no valid observation published on %s: %v", test.name, test.topic, err)
+		}
+		if observation.Result != 1.5 {
+			t.Errorf("%s: expected result 1.5, got %v", test.name, observation.Result)
+		}
+	}
+}
+
+func TestPublishObservationUnreachable(t *testing.T) {
+	defer stopMQTT()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+
+	server := configuration.ServerConfig{
+		URL:       "http://unreachable/v1.0/",
+		Transport: configuration.TransportMQTT,
+		MQTT:      configuration.MQTTConfig{Broker: "tcp://" + addr, TimeoutSeconds: 1},
+	}
+
+	if err := publishObservation(server, "1", module.Observation{Result: 1}); err == nil {
+		t.Errorf("expected an error publishing to an unreachable broker")
+	}
+}
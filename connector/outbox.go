@@ -0,0 +1,334 @@
+package connector
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	outboxFileName             = "outbox.db"
+	outboxKindObservation      = "observation"
+	outboxKindLocation         = "location"
+	defaultOutboxRetryInterval = 30
+)
+
+var (
+	outboxBucket = []byte("outbox")
+	box          *outbox
+)
+
+// outboxEntry is the persisted form of an ObservationMessage or LocationMessage
+type outboxEntry struct {
+	Kind        string              `json:"kind"`
+	ModuleID    string              `json:"moduleId"`
+	Host        string              `json:"host"`
+	ID          string              `json:"id"`
+	Observation *module.Observation `json:"observation,omitempty"`
+	Location    *module.Location    `json:"location,omitempty"`
+	Created     time.Time           `json:"created"`
+}
+
+// outbox keeps every observation and location on disk until the SensorThings
+// server acknowledged it, entries which could not be delivered are retried on
+// an interval, also after a restart of the connector
+type outbox struct {
+	db       *bolt.DB
+	mutex    *sync.Mutex
+	inFlight map[uint64]bool
+	ticker   *time.Ticker
+}
+
+// openOutbox opens or creates the outbox database in the given data directory
+func openOutbox(dataPath string) (*outbox, error) {
+	err := os.MkdirAll(dataPath, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create data directory %s: %v", dataPath, err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dataPath, outboxFileName), 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open outbox: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(outboxBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create outbox bucket: %v", err)
+	}
+
+	return &outbox{
+		db:       db,
+		mutex:    &sync.Mutex{},
+		inFlight: make(map[uint64]bool),
+	}, nil
+}
+
+// startOutbox opens the outbox and starts retrying pending entries when enabled in the config
+func startOutbox(config configuration.ConnectorConfig) {
+	if !config.Outbox.Enabled {
+		return
+	}
+
+	dataPath := getDataPath(config)
+	var err error
+	box, err = openOutbox(dataPath)
+	if err != nil {
+		log.Errorf("outbox disabled: %v", err)
+		return
+	}
+
+	interval := config.Outbox.RetryIntervalSeconds
+	if interval <= 0 {
+		interval = defaultOutboxRetryInterval
+	}
+
+	log.Infof("Outbox opened in %s, retrying undelivered messages every %v seconds", dataPath, interval)
+	box.ticker = time.NewTicker(time.Second * time.Duration(interval))
+	go func() {
+		for range box.ticker.C {
+			box.retry()
+		}
+	}()
+}
+
+// stopOutbox stops retrying and closes the outbox database
+func stopOutbox() {
+	if box == nil {
+		return
+	}
+
+	if box.ticker != nil {
+		box.ticker.Stop()
+	}
+
+	box.db.Close()
+}
+
+// addObservation stores an ObservationMessage and marks it as in flight
+func (o *outbox) addObservation(msg module.ObservationMessage) uint64 {
+	obs := msg.Observation
+	return o.add(outboxEntry{
+		Kind:        outboxKindObservation,
+		ModuleID:    msg.ModuleID,
+		Host:        msg.Host,
+		ID:          msg.DatastreamID,
+		Observation: &obs,
+		Created:     time.Now().UTC(),
+	})
+}
+
+// addLocation stores a LocationMessage and marks it as in flight
+func (o *outbox) addLocation(msg module.LocationMessage) uint64 {
+	loc := msg.Location
+	return o.add(outboxEntry{
+		Kind:     outboxKindLocation,
+		ModuleID: msg.ModuleID,
+		Host:     msg.Host,
+		ID:       msg.ThingID,
+		Location: &loc,
+		Created:  time.Now().UTC(),
+	})
+}
+
+// add persists an entry, returns 0 when the entry could not be stored
+func (o *outbox) add(entry outboxEntry) uint64 {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("unable to add message to outbox: %v", err)
+		return 0
+	}
+
+	// the key is marked as in flight before the entry is visible so retry does not send it
+	var key uint64
+	o.mutex.Lock()
+	err = o.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		key, _ = bucket.NextSequence()
+		o.inFlight[key] = true
+		return bucket.Put(itob(key), b)
+	})
+	if err != nil {
+		delete(o.inFlight, key)
+		o.mutex.Unlock()
+		log.Errorf("unable to add message to outbox: %v", err)
+		return 0
+	}
+	o.mutex.Unlock()
+
+	addPending(entry.ModuleID, 1)
+	return key
+}
+
+// release is called when a delivery attempt for an entry finished, the entry is
+// removed when it was delivered or can never be delivered
+func (o *outbox) release(key uint64, err error, permanent bool) {
+	if key == 0 {
+		return
+	}
+
+	o.mutex.Lock()
+	delete(o.inFlight, key)
+	o.mutex.Unlock()
+
+	if err == nil || permanent {
+		o.remove(key)
+	}
+}
+
+// remove deletes an entry from the outbox
+func (o *outbox) remove(key uint64) {
+	var entry outboxEntry
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		v := bucket.Get(itob(key))
+		if v == nil {
+			return nil
+		}
+
+		json.Unmarshal(v, &entry)
+		return bucket.Delete(itob(key))
+	})
+	if err != nil {
+		log.Errorf("unable to remove message from outbox: %v", err)
+		return
+	}
+
+	addPending(entry.ModuleID, -1)
+}
+
+// retry tries to deliver all entries which are not in flight, when a server
+// is still unreachable the remaining entries for that server are skipped
+func (o *outbox) retry() {
+	entries := make(map[uint64]outboxEntry)
+	keys := make([]uint64, 0)
+
+	o.mutex.Lock()
+	o.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(k, v []byte) error {
+			key := binary.BigEndian.Uint64(k)
+			if o.inFlight[key] {
+				return nil
+			}
+
+			entry := outboxEntry{}
+			if err := json.Unmarshal(v, &entry); err == nil {
+				entries[key] = entry
+				keys = append(keys, key)
+				o.inFlight[key] = true
+			}
+
+			return nil
+		})
+	})
+	o.mutex.Unlock()
+
+	if len(keys) == 0 {
+		return
+	}
+
+	log.Infof("Retrying %v undelivered message(s) from outbox", len(keys))
+	failedHosts := make(map[string]bool)
+	for _, key := range keys {
+		entry := entries[key]
+		if failedHosts[entry.Host] {
+			o.mutex.Lock()
+			delete(o.inFlight, key)
+			o.mutex.Unlock()
+			continue
+		}
+
+		var resp *http.Response
+		var err error
+		if entry.Kind == outboxKindLocation {
+			resp, err = postLocation(entry.Host, entry.ID, *entry.Location)
+		} else {
+			resp, err = postObservation(entry.Host, entry.ID, *entry.Observation)
+		}
+
+		o.release(key, err, isPermanentFailure(resp))
+		if err == nil {
+			redelivered(entry, nil)
+		} else if isPermanentFailure(resp) {
+			log.Errorf("outbox dropped %s for %s(%s) on %s: %v", entry.Kind, entry.ModuleID, entry.ID, entry.Host, err)
+			redelivered(entry, err)
+		} else {
+			failedHosts[entry.Host] = true
+		}
+	}
+}
+
+// updatePendingCounts sets the number of undelivered messages for every loaded module
+func (o *outbox) updatePendingCounts() {
+	counts := make(map[string]int64)
+	o.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(k, v []byte) error {
+			entry := outboxEntry{}
+			if err := json.Unmarshal(v, &entry); err == nil {
+				counts[entry.ModuleID]++
+			}
+
+			return nil
+		})
+	})
+
+	modulesMutex.RLock()
+	defer modulesMutex.RUnlock()
+
+	for id, m := range Modules {
+		atomic.StoreInt64(&(*m).GetConnectorModuleData().Status.OutboxPending, counts[id])
+	}
+}
+
+// isPermanentFailure returns true when the server rejected a message and
+// sending it again will not change the outcome
+func isPermanentFailure(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+
+	return resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests
+}
+
+func addPending(moduleID string, delta int64) {
+	if m, ok := getModule(moduleID); ok {
+		atomic.AddInt64(&(*m).GetConnectorModuleData().Status.OutboxPending, delta)
+	}
+}
+
+// redelivered counts an observation from the outbox which was delivered or rejected by the
+// server, the failed attempts before were handed to the outbox and not counted by the module
+func redelivered(entry outboxEntry, err error) {
+	m, ok := getModule(entry.ModuleID)
+	if !ok || entry.Kind != outboxKindObservation {
+		return
+	}
+
+	status := (*m).GetConnectorModuleData().Status
+	if err == nil {
+		atomic.AddInt64(&status.ObservationsPostedOk, 1)
+	} else {
+		atomic.AddInt64(&status.ObservationsPostedFailed, 1)
+	}
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,205 @@
+package connector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestIsPermanentFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		resp      *http.Response
+		permanent bool
+	}{
+		{"no response", nil, false},
+		{"created", &http.Response{StatusCode: http.StatusCreated}, false},
+		{"bad request", &http.Response{StatusCode: http.StatusBadRequest}, true},
+		{"not found", &http.Response{StatusCode: http.StatusNotFound}, true},
+		{"request timeout", &http.Response{StatusCode: http.StatusRequestTimeout}, false},
+		{"too many requests", &http.Response{StatusCode: http.StatusTooManyRequests}, false},
+		{"internal server error", &http.Response{StatusCode: http.StatusInternalServerError}, false},
+		{"bad gateway", &http.Response{StatusCode: http.StatusBadGateway}, false},
+	}
+
+	for _, test := range tests {
+		if got := isPermanentFailure(test.resp); got != test.permanent {
+			t.Errorf("%s: expected %v, got %v", test.name, test.permanent, got)
+		}
+	}
+}
+
+func TestResolveMessage(t *testing.T) {
+	box = nil
+	defer func() { box = nil }()
+
+	errFailed := fmt.Errorf("failed")
+	tests := []struct {
+		name      string
+		outbox    bool
+		key       uint64
+		err       error
+		permanent bool
+		outboxErr bool
+	}{
+		{"delivered without outbox", false, 0, nil, false, false},
+		{"failed without outbox", false, 0, errFailed, false, false},
+		{"delivered from outbox", true, 1, nil, false, false},
+		{"failed and kept in outbox", true, 1, errFailed, false, true},
+		{"rejected and removed from outbox", true, 1, errFailed, true, false},
+		{"failed and not stored in outbox", true, 0, errFailed, false, false},
+	}
+
+	for _, test := range tests {
+		box = nil
+		if test.outbox {
+			o, err := openOutbox(t.TempDir())
+			if err != nil {
+				t.Fatalf("unable to open outbox: %v", err)
+			}
+			box = o
+		}
+
+		var got error
+		called := false
+		resolveMessage(test.key, nil, test.err, test.permanent, func(resp *http.Response, err error) {
+			called = true
+			got = err
+		})
+
+		if !called {
+			t.Errorf("%s: status callback not called", test.name)
+		}
+		if module.IsOutboxError(got) != test.outboxErr {
+			t.Errorf("%s: expected outbox error %v, got %v", test.name, test.outboxErr, got)
+		}
+		if !test.outboxErr && got != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, got)
+		}
+
+		if box != nil {
+			box.db.Close()
+		}
+	}
+}
+
+// outboxKeys returns the keys of the entries in the outbox
+func outboxKeys(t *testing.T, o *outbox) []uint64 {
+	keys := make([]uint64, 0)
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, binary.BigEndian.Uint64(k))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("unable to read outbox: %v", err)
+	}
+
+	return keys
+}
+
+func TestOutboxReopen(t *testing.T) {
+	dir := t.TempDir()
+	o, err := openOutbox(dir)
+	if err != nil {
+		t.Fatalf("unable to open outbox: %v", err)
+	}
+
+	first := o.addObservation(module.ObservationMessage{ModuleID: "m", Host: "http://reopen/v1.0/", DatastreamID: "1", Observation: module.Observation{Result: 1}})
+	second := o.addLocation(module.LocationMessage{ModuleID: "m", Host: "http://reopen/v1.0/", ThingID: "2"})
+	delivered := o.addObservation(module.ObservationMessage{ModuleID: "m", Host: "http://reopen/v1.0/", DatastreamID: "3"})
+	o.release(delivered, nil, false)
+	o.db.Close()
+
+	// entries which were not delivered before the connector stopped are kept and not in flight
+	o, err = openOutbox(dir)
+	if err != nil {
+		t.Fatalf("unable to reopen outbox: %v", err)
+	}
+	defer o.db.Close()
+
+	if keys := outboxKeys(t, o); !equalKeys(keys, []uint64{first, second}) {
+		t.Errorf("expected entries %v after reopening, got %v", []uint64{first, second}, keys)
+	}
+	if len(o.inFlight) != 0 {
+		t.Errorf("expected no entries in flight after reopening, got %v", o.inFlight)
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	posted := make(map[string]int)
+	mutex := &sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		posted[r.URL.Path]++
+		mutex.Unlock()
+
+		switch {
+		case strings.Contains(r.URL.Path, "Datastreams(2)"):
+			w.WriteHeader(http.StatusBadRequest)
+		case strings.Contains(r.URL.Path, "Datastreams(3)"):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	host := server.URL + "/v1.0/"
+	breakersMutex.Lock()
+	breakers[host] = newBreaker(host, configuration.DeliveryConfig{InitialBackoffMs: 1, MaxBackoffMs: 1, BreakerThreshold: 100})
+	breakersMutex.Unlock()
+
+	tests := []struct {
+		name         string
+		datastreamID string
+		kept         bool
+	}{
+		{"delivered", "1", false},
+		{"rejected", "2", false},
+		{"unavailable", "3", true},
+	}
+
+	for _, test := range tests {
+		o, err := openOutbox(t.TempDir())
+		if err != nil {
+			t.Fatalf("unable to open outbox: %v", err)
+		}
+
+		key := o.addObservation(module.ObservationMessage{ModuleID: "m", Host: host, DatastreamID: test.datastreamID, Observation: module.Observation{Result: 1}})
+
+		// an entry which is still in flight is not retried
+		o.retry()
+		if n := len(outboxKeys(t, o)); n != 1 {
+			t.Fatalf("%s: expected the entry in flight to be kept, got %v entries", test.name, n)
+		}
+
+		o.release(key, fmt.Errorf("failed"), false)
+		o.retry()
+
+		kept := len(outboxKeys(t, o)) == 1
+		if kept != test.kept {
+			t.Errorf("%s: expected entry kept %v, got %v", test.name, test.kept, kept)
+		}
+		if len(o.inFlight) != 0 {
+			t.Errorf("%s: expected no entries in flight after retry, got %v", test.name, o.inFlight)
+		}
+
+		o.db.Close()
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if posted["/v1.0/Datastreams(1)/Observations"] != 1 {
+		t.Errorf("expected the delivered entry to be posted once, got %v", posted["/v1.0/Datastreams(1)/Observations"])
+	}
+}
@@ -0,0 +1,224 @@
+package connector
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+	log "github.com/sirupsen/logrus"
+)
+
+// Overflow policies for a full queue
+const (
+	OverflowBlock      = "block"
+	OverflowDropOldest = "drop-oldest"
+	OverflowSpill      = "spill"
+)
+
+const (
+	defaultQueueWorkers = 4
+	defaultQueueSize    = 1000
+)
+
+var (
+	pipeline    = configuration.PipelineConfig{}
+	queues      = make(map[string]*queue, 0)
+	queuesMutex = &sync.Mutex{}
+)
+
+// QueueStatus contains the metrics of the queue for a server
+type QueueStatus struct {
+	Host      string `json:"host"`
+	Depth     int    `json:"depth"`
+	Waiting   int    `json:"waiting"`
+	Capacity  int    `json:"capacity"`
+	Workers   int    `json:"workers"`
+	Overflow  string `json:"overflow"`
+	Processed int64  `json:"processed"`
+	Dropped   int64  `json:"dropped"`
+	Spilled   int64  `json:"spilled"`
+}
+
+// job is a message waiting in a queue, key is the outbox key of the message
+type job struct {
+	key  uint64
+	run  func()
+	drop func(err error)
+}
+
+// queue holds the messages for a single server which are send by a fixed number
+// of workers, a slow server therefore only fills up its own queue. Messages which
+// can not be spilled to the outbox wait for room in a full queue in waiting
+type queue struct {
+	host      string
+	capacity  int
+	workers   int
+	overflow  string
+	mutex     *sync.Mutex
+	notEmpty  *sync.Cond
+	notFull   *sync.Cond
+	jobs      []job
+	waiting   []job
+	processed int64
+	dropped   int64
+	spilled   int64
+}
+
+// initQueues sets the worker and queue settings used for every server
+func initQueues(config configuration.PipelineConfig) {
+	if config.Workers <= 0 {
+		config.Workers = defaultQueueWorkers
+	}
+
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+
+	switch config.Overflow {
+	case OverflowBlock, OverflowDropOldest:
+	case OverflowSpill:
+		if box == nil {
+			log.Warnf("Overflow policy %s needs the outbox to be enabled, using %s", OverflowSpill, OverflowBlock)
+			config.Overflow = OverflowBlock
+		}
+	default:
+		config.Overflow = OverflowBlock
+	}
+
+	pipeline = config
+}
+
+// getQueue returns the queue for a server, the queue and its workers are created on first use
+func getQueue(host string) *queue {
+	host = getHostWithSuffix(host)
+
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+
+	if q, ok := queues[host]; ok {
+		return q
+	}
+
+	q := &queue{
+		host:     host,
+		capacity: pipeline.QueueSize,
+		workers:  pipeline.Workers,
+		overflow: pipeline.Overflow,
+		mutex:    &sync.Mutex{},
+		jobs:     make([]job, 0),
+		waiting:  make([]job, 0),
+	}
+	q.notEmpty = sync.NewCond(q.mutex)
+	q.notFull = sync.NewCond(q.mutex)
+
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+
+	queues[host] = q
+	return q
+}
+
+// GetQueueStatus returns the metrics for all server queues
+func GetQueueStatus() []QueueStatus {
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+
+	status := make([]QueueStatus, 0)
+	for _, q := range queues {
+		status = append(status, q.status())
+	}
+
+	return status
+}
+
+// push adds a job to the queue, when the queue is full the overflow policy decides if push
+// waits until there is room, the oldest job is dropped or the new job is left in the outbox.
+// Jobs which can not be left in the outbox wait in memory up to the capacity of the queue
+func (q *queue) push(j job) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	switch q.overflow {
+	case OverflowDropOldest:
+		if len(q.jobs) >= q.capacity {
+			oldest := q.jobs[0]
+			q.jobs = q.jobs[1:]
+			q.dropped++
+			go oldest.drop(fmt.Errorf("queue for %s is full, message dropped", q.host))
+		}
+	case OverflowSpill:
+		if len(q.jobs) >= q.capacity || len(q.waiting) > 0 {
+			if j.key != 0 {
+				q.spilled++
+				go j.drop(fmt.Errorf("queue for %s is full", q.host))
+				return
+			}
+
+			if len(q.waiting) >= q.capacity {
+				q.dropped++
+				go j.drop(fmt.Errorf("queue for %s is full, message dropped", q.host))
+				return
+			}
+
+			q.waiting = append(q.waiting, j)
+			return
+		}
+	default:
+		for len(q.jobs) >= q.capacity {
+			q.notFull.Wait()
+		}
+	}
+
+	q.jobs = append(q.jobs, j)
+	q.notEmpty.Signal()
+}
+
+// next waits for a job and removes it from the queue, a waiting job takes its place
+func (q *queue) next() job {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.jobs) == 0 {
+		q.notEmpty.Wait()
+	}
+
+	j := q.jobs[0]
+	q.jobs = q.jobs[1:]
+	if len(q.waiting) > 0 {
+		q.jobs = append(q.jobs, q.waiting[0])
+		q.waiting = q.waiting[1:]
+	} else {
+		q.notFull.Signal()
+	}
+
+	return j
+}
+
+// work runs the jobs in the queue
+func (q *queue) work() {
+	for {
+		j := q.next()
+		j.run()
+		atomic.AddInt64(&q.processed, 1)
+	}
+}
+
+func (q *queue) status() QueueStatus {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return QueueStatus{
+		Host:      module.RedactURL(q.host),
+		Depth:     len(q.jobs),
+		Waiting:   len(q.waiting),
+		Capacity:  q.capacity,
+		Workers:   q.workers,
+		Overflow:  q.overflow,
+		Processed: atomic.LoadInt64(&q.processed),
+		Dropped:   q.dropped,
+		Spilled:   q.spilled,
+	}
+}
@@ -0,0 +1,155 @@
+package connector
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestQueue(overflow string, capacity int) *queue {
+	q := &queue{
+		host:     "http://queue/",
+		capacity: capacity,
+		workers:  1,
+		overflow: overflow,
+		mutex:    &sync.Mutex{},
+		jobs:     make([]job, 0),
+		waiting:  make([]job, 0),
+	}
+	q.notEmpty = sync.NewCond(q.mutex)
+	q.notFull = sync.NewCond(q.mutex)
+	return q
+}
+
+func TestQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow string
+		keys     []uint64
+		jobs     []uint64
+		waiting  []uint64
+		dropped  []uint64
+		spilled  int64
+	}{
+		{"below capacity", OverflowBlock, []uint64{1, 2}, []uint64{1, 2}, []uint64{}, []uint64{}, 0},
+		{"drop-oldest", OverflowDropOldest, []uint64{1, 2, 3, 4}, []uint64{3, 4}, []uint64{}, []uint64{1, 2}, 0},
+		{"spill", OverflowSpill, []uint64{1, 2, 3, 4}, []uint64{1, 2}, []uint64{}, []uint64{3, 4}, 2},
+		{"spill without outbox key", OverflowSpill, []uint64{1, 2, 0, 3}, []uint64{1, 2}, []uint64{0}, []uint64{3}, 1},
+		{"spill with full waiting list", OverflowSpill, []uint64{1, 2, 0, 0, 0}, []uint64{1, 2}, []uint64{0, 0}, []uint64{0}, 0},
+	}
+
+	for _, test := range tests {
+		q := newTestQueue(test.overflow, 2)
+		dropped := make(chan uint64, len(test.keys))
+		for _, key := range test.keys {
+			k := key
+			q.push(job{key: k, run: func() {}, drop: func(err error) { dropped <- k }})
+		}
+
+		if !equalKeys(jobKeys(q.jobs), test.jobs) {
+			t.Errorf("%s: expected jobs %v, got %v", test.name, test.jobs, jobKeys(q.jobs))
+		}
+		if !equalKeys(jobKeys(q.waiting), test.waiting) {
+			t.Errorf("%s: expected waiting %v, got %v", test.name, test.waiting, jobKeys(q.waiting))
+		}
+		if q.spilled != test.spilled {
+			t.Errorf("%s: expected %v spilled, got %v", test.name, test.spilled, q.spilled)
+		}
+
+		// every dropped or spilled job has its callback called
+		got := make(map[uint64]bool)
+		for range test.dropped {
+			select {
+			case k := <-dropped:
+				got[k] = true
+			case <-time.After(time.Second):
+			}
+		}
+		for _, k := range test.dropped {
+			if !got[k] {
+				t.Errorf("%s: drop not called for job %v", test.name, k)
+			}
+		}
+	}
+}
+
+func TestQueueBlock(t *testing.T) {
+	q := newTestQueue(OverflowBlock, 1)
+	q.push(job{key: 1})
+
+	pushed := make(chan bool)
+	go func() {
+		q.push(job{key: 2})
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatalf("push did not wait for room in a full queue")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	if j := q.next(); j.key != 1 {
+		t.Errorf("expected job 1, got %v", j.key)
+	}
+
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatalf("push did not continue when there was room in the queue")
+	}
+
+	if !equalKeys(jobKeys(q.jobs), []uint64{2}) || len(q.waiting) != 0 {
+		t.Errorf("expected jobs [2] and nothing waiting, got %v and %v", jobKeys(q.jobs), jobKeys(q.waiting))
+	}
+}
+
+func TestQueueWaitingOrder(t *testing.T) {
+	q := newTestQueue(OverflowSpill, 2)
+	ran := make([]int, 0)
+	for i := 0; i < 4; i++ {
+		n := i
+		q.push(job{run: func() { ran = append(ran, n) }, drop: func(err error) { t.Errorf("job %v dropped: %v", n, err) }})
+	}
+
+	if len(q.jobs) != 2 || len(q.waiting) != 2 {
+		t.Fatalf("expected 2 jobs and 2 waiting, got %v and %v", len(q.jobs), len(q.waiting))
+	}
+
+	// a waiting job moves into the queue for every job which is taken
+	for i := 0; i < 4; i++ {
+		q.next().run()
+		if len(q.waiting) > 0 && len(q.jobs) < q.capacity {
+			t.Errorf("job waiting while the queue has room")
+		}
+	}
+
+	for i, n := range ran {
+		if n != i {
+			t.Errorf("expected job %v, got %v", i, n)
+		}
+	}
+}
+
+func jobKeys(jobs []job) []uint64 {
+	keys := make([]uint64, 0)
+	for _, j := range jobs {
+		keys = append(keys, j.key)
+	}
+
+	return keys
+}
+
+func equalKeys(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,349 @@
+package connector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gost/sensorthings-connector/module"
+)
+
+var selfLinkID = regexp.MustCompile(`\(([^()]+)\)$`)
+
+// sensorThingsClient implements module.ISensorThingsClient, entity ids are cached per
+// server so entities are only looked up once. The lookup of an entity only locks its own
+// cache key so a slow server does not hold up the lookups for other servers
+type sensorThingsClient struct {
+	mutex *sync.Mutex
+	cache map[string]string
+	locks map[string]*sync.Mutex
+}
+
+// entityList is the response of a SensorThings collection request
+type entityList struct {
+	Value []map[string]interface{} `json:"value"`
+}
+
+var sensorThings = newSensorThingsClient()
+
+func newSensorThingsClient() *sensorThingsClient {
+	return &sensorThingsClient{
+		mutex: &sync.Mutex{},
+		cache: make(map[string]string),
+		locks: make(map[string]*sync.Mutex),
+	}
+}
+
+// ProvisionDatastream looks up the Datastream by name for the given Thing, the Thing,
+// Sensor, ObservedProperty and Datastream are created when they do not exist
+func (s *sensorThingsClient) ProvisionDatastream(host string, thing module.ThingDefinition, ds module.DatastreamDefinition) (string, error) {
+	host = getHostWithSuffix(resolveServer(host))
+	cacheKey := fmt.Sprintf("%s|Things(%s)/Datastreams|%s", host, thing.Name, ds.Name)
+	if id, ok := s.cached(cacheKey); ok {
+		return id, nil
+	}
+
+	unlock := s.lock(cacheKey)
+	defer unlock()
+
+	if id, ok := s.cached(cacheKey); ok {
+		return id, nil
+	}
+
+	thingID, err := s.provisionThing(host, thing)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := findEntity(host, fmt.Sprintf("Things(%s)/Datastreams", thingID), ds.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if len(id) == 0 {
+		sensorID, err := s.provision(host, "Sensors", ds.Sensor.Name, ds.Sensor)
+		if err != nil {
+			return "", err
+		}
+
+		propertyID, err := s.provision(host, "ObservedProperties", ds.ObservedProperty.Name, ds.ObservedProperty)
+		if err != nil {
+			return "", err
+		}
+
+		observationType := ds.ObservationType
+		if len(observationType) == 0 {
+			observationType = "http://www.opengis.net/def/observationType/OGC-OM/2.0/OM_Measurement"
+		}
+
+		id, err = createEntity(host, "Datastreams", map[string]interface{}{
+			"name":              ds.Name,
+			"description":       ds.Description,
+			"observationType":   observationType,
+			"unitOfMeasurement": ds.UnitOfMeasurement,
+			"Thing":             entityRef(thingID),
+			"Sensor":            entityRef(sensorID),
+			"ObservedProperty":  entityRef(propertyID),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	s.store(cacheKey, id)
+	return id, nil
+}
+
+// ResolveDatastream returns the id of an existing Datastream referenced by Thing and Datastream
+// name or by an OData filter, the reference should match exactly one Datastream
+func (s *sensorThingsClient) ResolveDatastream(host string, reference module.DatastreamReference) (string, error) {
+	host = getHostWithSuffix(resolveServer(host))
+
+	if len(reference.Filter) > 0 {
+		entities, err := getEntities(host, fmt.Sprintf("Datastreams?$top=2&$filter=%s", url.QueryEscape(reference.Filter)))
+		if err != nil {
+			return "", err
+		}
+
+		if len(entities) != 1 {
+			return "", fmt.Errorf("filter %s matches %v Datastreams, expected 1", reference.Filter, len(entities))
+		}
+
+		return entityID(entities[0]["@iot.id"]), nil
+	}
+
+	thingID, err := findEntity(host, "Things", reference.Thing)
+	if err != nil {
+		return "", err
+	}
+
+	if len(thingID) == 0 {
+		return "", fmt.Errorf("Thing %s not found", reference.Thing)
+	}
+
+	id, err := findEntity(host, fmt.Sprintf("Things(%s)/Datastreams", thingID), reference.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if len(id) == 0 {
+		return "", fmt.Errorf("Datastream %s not found for Thing %s", reference.Name, reference.Thing)
+	}
+
+	return id, nil
+}
+
+// LatestPhenomenonTime returns the phenomenonTime of the latest Observation of a Datastream,
+// an empty string is returned when the Datastream has no Observations
+func (s *sensorThingsClient) LatestPhenomenonTime(host, datastreamID string) (string, error) {
+	host = getHostWithSuffix(resolveServer(host))
+	entities, err := getEntities(host, fmt.Sprintf("Datastreams(%s)/Observations?$orderby=%s&$top=1&$select=phenomenonTime", datastreamID, url.QueryEscape("phenomenonTime desc")))
+	if err != nil || len(entities) == 0 {
+		return "", err
+	}
+
+	t, _ := entities[0]["phenomenonTime"].(string)
+	return t, nil
+}
+
+// provisionFeatureOfInterest returns the id of a FeatureOfInterest looked up by name, the
+// FeatureOfInterest is created when it does not exist
+func (s *sensorThingsClient) provisionFeatureOfInterest(host string, feature module.FeatureOfInterest) (string, error) {
+	host = getHostWithSuffix(resolveServer(host))
+	if len(feature.EncodingType) == 0 {
+		feature.EncodingType = "application/vnd.geo+json"
+	}
+
+	return s.provision(host, "FeaturesOfInterest", feature.Name, feature)
+}
+
+// provisionThing looks up a Thing by name and creates it with its Location when it does not exist
+func (s *sensorThingsClient) provisionThing(host string, thing module.ThingDefinition) (string, error) {
+	body := map[string]interface{}{
+		"name":        thing.Name,
+		"description": thing.Description,
+	}
+
+	if len(thing.Properties) > 0 {
+		body["properties"] = thing.Properties
+	}
+
+	if thing.Location != nil {
+		body["Locations"] = []module.Location{*thing.Location}
+	}
+
+	return s.provision(host, "Things", thing.Name, body)
+}
+
+// provision returns the id of the entity with the given name in a collection, the
+// entity is created from body when it does not exist
+func (s *sensorThingsClient) provision(host, collection, name string, body interface{}) (string, error) {
+	if len(name) == 0 {
+		return "", fmt.Errorf("no name set for entity in %s", collection)
+	}
+
+	cacheKey := fmt.Sprintf("%s|%s|%s", host, collection, name)
+	if id, ok := s.cached(cacheKey); ok {
+		return id, nil
+	}
+
+	unlock := s.lock(cacheKey)
+	defer unlock()
+
+	if id, ok := s.cached(cacheKey); ok {
+		return id, nil
+	}
+
+	id, err := findEntity(host, collection, name)
+	if err != nil {
+		return "", err
+	}
+
+	if len(id) == 0 {
+		id, err = createEntity(host, collection, body)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	s.store(cacheKey, id)
+	return id, nil
+}
+
+// cached returns the cached id for a cache key
+func (s *sensorThingsClient) cached(cacheKey string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id, ok := s.cache[cacheKey]
+	return id, ok
+}
+
+// store adds an id to the cache
+func (s *sensorThingsClient) store(cacheKey, id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.cache[cacheKey] = id
+}
+
+// lock locks a cache key so an entity is looked up and created only once at a time,
+// returns the function to unlock the key
+func (s *sensorThingsClient) lock(cacheKey string) func() {
+	s.mutex.Lock()
+	l, ok := s.locks[cacheKey]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[cacheKey] = l
+	}
+	s.mutex.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// findEntity returns the id of the first entity in a collection with the given name
+// or an empty string when there is no such entity
+func findEntity(host, collection, name string) (string, error) {
+	filter := fmt.Sprintf("name eq '%s'", strings.Replace(name, "'", "''", -1))
+	entities, err := getEntities(host, fmt.Sprintf("%s?$filter=%s", collection, url.QueryEscape(filter)))
+	if err != nil {
+		return "", err
+	}
+
+	if len(entities) == 0 {
+		return "", nil
+	}
+
+	return entityID(entities[0]["@iot.id"]), nil
+}
+
+// getEntities requests a collection on the server
+func getEntities(host, path string) ([]map[string]interface{}, error) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s%s", getHostWithSuffix(host), path), nil)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := getServerClient(host).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected StatusCode requesting %s, expected %v got %v", path, http.StatusOK, resp.StatusCode)
+	}
+
+	list := entityList{}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse response for %s: %v", path, err)
+	}
+
+	return list.Value, nil
+}
+
+// createEntity posts an entity to a collection and returns the id of the created entity,
+// the id is taken from the response body or the Location header
+func createEntity(host, collection string, body interface{}) (string, error) {
+	if dryRun {
+		if sink != nil {
+			sink.write(sinkRecord{Kind: "entity", URL: module.RedactURL(fmt.Sprintf("%s%s", getHostWithSuffix(host), collection)), Payload: body})
+		}
+
+		return dryRunEntityID(collection), nil
+	}
+
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s%s", getHostWithSuffix(host), collection), bytes.NewBuffer(b))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := getServerClient(host).Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("unable to create entity in %s, expected %v got %v: %s", collection, http.StatusCreated, resp.StatusCode, string(content))
+	}
+
+	entity := make(map[string]interface{})
+	if err := json.Unmarshal(content, &entity); err == nil {
+		if id := entityID(entity["@iot.id"]); len(id) > 0 {
+			return id, nil
+		}
+	}
+
+	if m := selfLinkID.FindStringSubmatch(resp.Header.Get("Location")); m != nil {
+		return strings.Trim(m[1], "'"), nil
+	}
+
+	return "", fmt.Errorf("unable to get id of created entity in %s", collection)
+}
+
+// entityID converts an @iot.id value to a string
+func entityID(v interface{}) string {
+	switch id := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64)
+	case string:
+		return id
+	default:
+		return fmt.Sprintf("%v", id)
+	}
+}
+
+// entityRef creates a reference to an existing entity
+func entityRef(id string) map[string]interface{} {
+	return map[string]interface{}{"@iot.id": toEntityID(id)}
+}
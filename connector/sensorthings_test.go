@@ -0,0 +1,138 @@
+package connector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gost/sensorthings-connector/module"
+)
+
+// entityServer is a SensorThings server without entities which creates every posted
+// entity, requests are answered when release is closed
+type entityServer struct {
+	url     string
+	mutex   *sync.Mutex
+	gets    int
+	created []string
+}
+
+func newEntityServer(t *testing.T, release chan struct{}) *entityServer {
+	s := &entityServer{mutex: &sync.Mutex{}, created: make([]string, 0)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if r.Method == "GET" {
+			s.gets++
+			w.Write([]byte(`{"value":[]}`))
+			return
+		}
+
+		collection := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		s.created = append(s.created, collection)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"@iot.id":` + strconv.Itoa(len(s.created)) + `}`))
+	}))
+	t.Cleanup(server.Close)
+
+	s.url = server.URL + "/v1.0/"
+	return s
+}
+
+func released() chan struct{} {
+	release := make(chan struct{})
+	close(release)
+	return release
+}
+
+func TestProvisionDatastream(t *testing.T) {
+	server := newEntityServer(t, released())
+	client := newSensorThingsClient()
+
+	thing := module.ThingDefinition{Name: "thing"}
+	ds := module.DatastreamDefinition{Name: "temperature", Sensor: module.SensorDefinition{Name: "sensor"}, ObservedProperty: module.ObservedPropertyDefinition{Name: "property"}}
+	id, err := client.ProvisionDatastream(server.url, thing, ds)
+	if err != nil {
+		t.Fatalf("unable to provision Datastream: %v", err)
+	}
+
+	expected := []string{"Things", "Sensors", "ObservedProperties", "Datastreams"}
+	if strings.Join(server.created, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v to be created, got %v", expected, server.created)
+	}
+	if id != "4" {
+		t.Errorf("expected Datastream 4, got %v", id)
+	}
+
+	// the id is cached
+	gets := server.gets
+	if id, err := client.ProvisionDatastream(server.url, thing, ds); err != nil || id != "4" {
+		t.Errorf("expected cached Datastream 4, got %v %v", id, err)
+	}
+	if server.gets != gets || len(server.created) != len(expected) {
+		t.Errorf("expected the cached Datastream not to be requested again")
+	}
+}
+
+func TestProvisionFeatureOfInterestOnce(t *testing.T) {
+	server := newEntityServer(t, released())
+	client := newSensorThingsClient()
+
+	wg := sync.WaitGroup{}
+	ids := make(chan string, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := client.provisionFeatureOfInterest(server.url, module.FeatureOfInterest{Name: "feature"})
+			if err != nil {
+				t.Errorf("unable to provision FeatureOfInterest: %v", err)
+			}
+			ids <- id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	for id := range ids {
+		if id != "1" {
+			t.Errorf("expected FeatureOfInterest 1, got %v", id)
+		}
+	}
+	if len(server.created) != 1 {
+		t.Errorf("expected the FeatureOfInterest to be created once, got %v", server.created)
+	}
+}
+
+func TestProvisionFeatureOfInterestSlowServer(t *testing.T) {
+	release := make(chan struct{})
+	slow := newEntityServer(t, release)
+	defer close(release)
+	fast := newEntityServer(t, released())
+	client := newSensorThingsClient()
+
+	go client.provisionFeatureOfInterest(slow.url, module.FeatureOfInterest{Name: "feature"})
+	time.Sleep(time.Millisecond * 50)
+
+	// a hanging server does not hold up the lookups for other servers
+	done := make(chan error)
+	go func() {
+		_, err := client.provisionFeatureOfInterest(fast.url, module.FeatureOfInterest{Name: "feature"})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unable to provision FeatureOfInterest: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("provisioning waited for another server")
+	}
+}
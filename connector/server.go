@@ -28,6 +28,7 @@ func StartHTTPServer(host string, port int, modules map[string]*module.IConnecto
 	router := httprouter.New()
 	router.GET("/Modules", moduleInfoHandler)
 	router.POST("/Modules/State", stateHandler)
+	router.POST("/Modules/Backfill", backfillHandler)
 
 	// register all endpoints added by the modules
 	for _, i := range moduleInfos.Modules {
@@ -38,6 +39,18 @@ func StartHTTPServer(host string, port int, modules map[string]*module.IConnecto
 					{
 						router.GET(o.Path, o.Handler)
 					}
+				case module.HTTPOperationPost:
+					{
+						router.POST(o.Path, o.Handler)
+					}
+				case module.HTTPOperationPatch:
+					{
+						router.PATCH(o.Path, o.Handler)
+					}
+				case module.HTTPOperationDelete:
+					{
+						router.DELETE(o.Path, o.Handler)
+					}
 				}
 			}
 		}
@@ -47,7 +60,10 @@ func StartHTTPServer(host string, port int, modules map[string]*module.IConnecto
 }
 
 func moduleInfoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	b, _ := json.MarshalIndent(moduleInfos, "", "   ")
+	infos := moduleInfos
+	infos.Servers = GetServerStatus()
+	infos.Queues = GetQueueStatus()
+	b, _ := json.MarshalIndent(infos, "", "   ")
 	w.Write(b)
 }
 
@@ -69,42 +85,32 @@ func stateHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		return
 	}
 
-	var module *module.IConnectorModule
-	for _, m := range Modules {
-		if (*m).GetID() == state.ModuleID {
-			module = m
-		}
-	}
-
-	if module == nil {
+	m, ok := getModule(state.ModuleID)
+	if !ok {
 		state.Errors = append(state.Errors, fmt.Sprintf("Unable to find module %s", state.ModuleID))
 		sendState(nil, state, w, r, http.StatusBadRequest)
 		return
 	}
 
 	if state.On {
-		error := startModule(module, false)
+		error := startModule(m, false)
 		if error != nil {
-			state.Errors = append(state.Errors, (*module).GetConnectorModuleData().Status.LastErrors...)
-			sendState(module, state, w, r, http.StatusInternalServerError)
+			state.Errors = append(state.Errors, (*m).GetConnectorModuleData().Status.LastErrors...)
+			sendState(m, state, w, r, http.StatusInternalServerError)
 			return
 		}
 	} else {
-		stopModule(module)
+		stopModule(m, module.StateStopped)
 	}
 
-	sendState(module, state, w, r, http.StatusOK)
+	sendState(m, state, w, r, http.StatusOK)
 }
 
 func sendState(module *module.IConnectorModule, state State, w http.ResponseWriter, r *http.Request, status int) {
 	js, _ := json.Marshal(state)
 
 	if status == http.StatusOK {
-		stateString := "running"
-		if !state.On {
-			stateString = "stopped"
-		}
-
+		stateString := (*module).GetConnectorModuleData().GetState()
 		log.Infof("Requested state change for module with id: %s from REST service, module is now %v", (*module).GetID(), stateString)
 	} else {
 		log.Errorf("Requested state change for module with id: %s from REST service, but failed: %v", state.ModuleID, state.Errors)
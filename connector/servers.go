@@ -0,0 +1,46 @@
+package connector
+
+import (
+	"github.com/gost/sensorthings-connector/configuration"
+)
+
+var (
+	// servers holds the configured SensorThings servers by url
+	servers = make(map[string]configuration.ServerConfig, 0)
+	// serverNames holds the url for every named server
+	serverNames = make(map[string]string, 0)
+)
+
+// initServers registers the configured servers
+func initServers(config []configuration.ServerConfig) {
+	for _, s := range config {
+		servers[getHostWithSuffix(s.URL)] = s
+		if len(s.Name) > 0 {
+			serverNames[s.Name] = s.URL
+		}
+	}
+}
+
+// resolveServer returns the url for a server name, urls are returned as is
+func resolveServer(nameOrURL string) string {
+	if u, ok := serverNames[nameOrURL]; ok {
+		return u
+	}
+
+	return nameOrURL
+}
+
+// getServer returns the configuration for a server by name or url, servers
+// which are not configured get the default settings
+func getServer(host string) configuration.ServerConfig {
+	if s, ok := servers[getHostWithSuffix(resolveServer(host))]; ok {
+		return s
+	}
+
+	return configuration.ServerConfig{URL: host, Transport: configuration.TransportHTTP}
+}
+
+// isMQTTServer returns true when observations for the server are published over MQTT
+func isMQTTServer(host string) bool {
+	return getServer(host).Transport == configuration.TransportMQTT
+}
@@ -0,0 +1,41 @@
+package connector
+
+import (
+	"testing"
+
+	"github.com/gost/sensorthings-connector/configuration"
+)
+
+func TestServerRegistry(t *testing.T) {
+	registerTestServer(t, configuration.ServerConfig{Name: "production", URL: "http://production/v1.0/", TimeoutSeconds: 5})
+	registerTestServer(t, configuration.ServerConfig{Name: "broker", URL: "http://broker/v1.0", Transport: configuration.TransportMQTT})
+
+	tests := []struct {
+		name      string
+		host      string
+		url       string
+		server    string
+		transport string
+	}{
+		{"name", "production", "http://production/v1.0/", "http://production/v1.0/", ""},
+		{"url", "http://production/v1.0/", "http://production/v1.0/", "http://production/v1.0/", ""},
+		{"url without slash", "http://production/v1.0", "http://production/v1.0", "http://production/v1.0/", ""},
+		{"mqtt server by name", "broker", "http://broker/v1.0", "http://broker/v1.0", configuration.TransportMQTT},
+		{"unknown url", "http://other/v1.0/", "http://other/v1.0/", "http://other/v1.0/", configuration.TransportHTTP},
+		{"unknown name", "staging", "staging", "staging", configuration.TransportHTTP},
+	}
+
+	for _, test := range tests {
+		if url := resolveServer(test.host); url != test.url {
+			t.Errorf("%s: expected url %s, got %s", test.name, test.url, url)
+		}
+
+		server := getServer(test.host)
+		if server.URL != test.server || server.Transport != test.transport {
+			t.Errorf("%s: expected server %s with transport %q, got %s with %q", test.name, test.server, test.transport, server.URL, server.Transport)
+		}
+		if isMQTTServer(test.host) != (test.transport == configuration.TransportMQTT) {
+			t.Errorf("%s: unexpected mqtt transport", test.name)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sinkFileName       = "messages.ndjson"
+	defaultSinkMaxSize = 10
+	defaultSinkFiles   = 5
+)
+
+var (
+	sink         *fileSink
+	dryRun       bool
+	dryRunEntity int64
+)
+
+// sinkRecord is a single line in the sink file
+type sinkRecord struct {
+	Time      time.Time   `json:"time"`
+	Kind      string      `json:"kind"`
+	ModuleID  string      `json:"moduleId"`
+	URL       string      `json:"url"`
+	Transport string      `json:"transport"`
+	DryRun    bool        `json:"dryRun"`
+	Payload   interface{} `json:"payload"`
+}
+
+// fileSink writes every message to a NDJSON file, the file is rotated when it reaches
+// the maximum size and only the newest files are kept
+type fileSink struct {
+	mutex    *sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// startSink opens the sink when enabled in the config, dry run always writes to the sink
+func startSink(config configuration.ConnectorConfig) {
+	dryRun = config.DryRun
+	if !config.Sink.Enabled && !dryRun {
+		return
+	}
+
+	path := config.Sink.Path
+	if len(path) == 0 {
+		path = filepath.Join(getDataPath(config), "sink")
+	}
+
+	maxSize := config.Sink.MaxSizeMB
+	if maxSize <= 0 {
+		maxSize = defaultSinkMaxSize
+	}
+
+	maxFiles := config.Sink.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = defaultSinkFiles
+	}
+
+	s := &fileSink{
+		mutex:    &sync.Mutex{},
+		path:     path,
+		maxSize:  int64(maxSize) * 1024 * 1024,
+		maxFiles: maxFiles,
+	}
+
+	if err := s.open(); err != nil {
+		log.Errorf("sink disabled: %v", err)
+		return
+	}
+
+	sink = s
+	if dryRun {
+		log.Warnf("Dry run enabled, messages are written to %s and not send to the servers", path)
+	} else {
+		log.Infof("Writing all messages to %s", path)
+	}
+}
+
+// stopSink closes the sink file
+func stopSink() {
+	if sink == nil {
+		return
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	sink.file.Close()
+}
+
+// writeObservation adds an ObservationMessage to the sink
+func (s *fileSink) writeObservation(msg module.ObservationMessage) {
+	s.write(sinkRecord{
+		Kind:      outboxKindObservation,
+		ModuleID:  msg.ModuleID,
+		URL:       module.RedactURL(constructObservationURL(msg.Host, msg.DatastreamID)),
+		Transport: getServer(msg.Host).Transport,
+		Payload:   msg.Observation,
+	})
+}
+
+// writeLocation adds a LocationMessage to the sink
+func (s *fileSink) writeLocation(msg module.LocationMessage) {
+	s.write(sinkRecord{
+		Kind:      outboxKindLocation,
+		ModuleID:  msg.ModuleID,
+		URL:       module.RedactURL(constructLocationURL(msg.Host, msg.ThingID)),
+		Transport: configuration.TransportHTTP,
+		Payload:   msg.Location,
+	})
+}
+
+func (s *fileSink) write(record sinkRecord) {
+	record.Time = time.Now().UTC()
+	record.DryRun = dryRun
+	if len(record.Transport) == 0 {
+		record.Transport = configuration.TransportHTTP
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("unable to write message to sink: %v", err)
+		return
+	}
+
+	b = append(b, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.size > 0 && s.size+int64(len(b)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			log.Errorf("unable to rotate sink: %v", err)
+		}
+	}
+
+	n, err := s.file.Write(b)
+	s.size += int64(n)
+	if err != nil {
+		log.Errorf("unable to write message to sink: %v", err)
+	}
+}
+
+// open opens or creates the current sink file
+func (s *fileSink) open() error {
+	err := os.MkdirAll(s.path, 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create sink directory %s: %v", s.path, err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.path, sinkFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open sink: %v", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("unable to open sink: %v", err)
+	}
+
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file using a timestamp, opens a new file and removes the oldest files
+func (s *fileSink) rotate() error {
+	s.file.Close()
+	name := fmt.Sprintf("messages-%s.ndjson", time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.Rename(filepath.Join(s.path, sinkFileName), filepath.Join(s.path, name)); err != nil {
+		log.Errorf("unable to rotate sink: %v", err)
+	}
+
+	rotated, _ := filepath.Glob(filepath.Join(s.path, "messages-*.ndjson"))
+	sort.Strings(rotated)
+	for len(rotated) > s.maxFiles-1 {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+
+	return s.open()
+}
+
+// dryRunEntityID returns a fake id for an entity which is not created because of the dry run
+func dryRunEntityID(collection string) string {
+	return fmt.Sprintf("dry-run-%s-%v", collection, atomic.AddInt64(&dryRunEntity, 1))
+}
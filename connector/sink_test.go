@@ -0,0 +1,87 @@
+package connector
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gost/sensorthings-connector/module"
+)
+
+// newTestSink opens a sink in a temporary directory which rotates before every message but the first
+func newTestSink(t *testing.T, maxFiles int) *fileSink {
+	s := &fileSink{mutex: &sync.Mutex{}, path: t.TempDir(), maxSize: 1, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		t.Fatalf("unable to open sink: %v", err)
+	}
+
+	t.Cleanup(func() { s.file.Close() })
+	return s
+}
+
+func TestSinkRotate(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxFiles int
+		messages int
+		rotated  int
+	}{
+		{"single message", 3, 1, 0},
+		{"rotated files", 3, 3, 2},
+		{"oldest files removed", 3, 6, 2},
+		{"single file", 1, 3, 0},
+	}
+
+	for _, test := range tests {
+		s := newTestSink(t, test.maxFiles)
+		for i := 0; i < test.messages; i++ {
+			s.writeObservation(module.ObservationMessage{Host: "http://gost/v1.0/", DatastreamID: "1", ModuleID: "m1", Observation: module.Observation{Result: i}})
+		}
+
+		rotated, _ := filepath.Glob(filepath.Join(s.path, "messages-*.ndjson"))
+		if len(rotated) != test.rotated {
+			t.Errorf("%s: expected %v rotated files, got %v", test.name, test.rotated, len(rotated))
+		}
+
+		// the current file contains the last message
+		f, err := os.Open(filepath.Join(s.path, sinkFileName))
+		if err != nil {
+			t.Errorf("%s: unable to open sink file: %v", test.name, err)
+			continue
+		}
+
+		records := make([]sinkRecord, 0)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r sinkRecord
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Errorf("%s: invalid record %s: %v", test.name, scanner.Text(), err)
+			}
+			records = append(records, r)
+		}
+		f.Close()
+
+		if len(records) != 1 || records[0].URL != "http://gost/v1.0/Datastreams(1)/Observations" || records[0].ModuleID != "m1" {
+			t.Errorf("%s: expected the last observation in the sink file, got %+v", test.name, records)
+		}
+	}
+}
+
+func TestSinkReopen(t *testing.T) {
+	s := newTestSink(t, 3)
+	s.maxSize = 1024 * 1024
+	s.writeObservation(module.ObservationMessage{Host: "http://gost/v1.0/", DatastreamID: "1", Observation: module.Observation{Result: 1}})
+	size := s.size
+	s.file.Close()
+
+	// a restarted connector appends to the existing file
+	if err := s.open(); err != nil {
+		t.Fatalf("unable to reopen sink: %v", err)
+	}
+	if s.size != size {
+		t.Errorf("expected size %v after reopening, got %v", size, s.size)
+	}
+}
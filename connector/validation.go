@@ -0,0 +1,217 @@
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gost/sensorthings-connector/configuration"
+	"github.com/gost/sensorthings-connector/module"
+	log "github.com/sirupsen/logrus"
+)
+
+var validation = configuration.ValidationConfig{}
+
+// validateModules checks the servers, Datastreams and Things used by every loaded module
+// and stores the problems in the status of the module. The servers of all modules are checked
+// at the same time so every unreachable server delays the startup by one timeout at most
+func validateModules(config configuration.ValidationConfig) {
+	validation = config
+	if !config.Enabled {
+		return
+	}
+
+	modules := make([]*module.IConnectorModule, 0)
+	hosts := make([]string, 0)
+	for _, m := range loadedModules() {
+		data := (*m).GetConnectorModuleData()
+		if data.GetState() == module.StateFailed {
+			continue
+		}
+
+		modules = append(modules, m)
+		hosts = append(hosts, moduleHosts(data)...)
+	}
+
+	servers := checkServers(hosts)
+	wg := sync.WaitGroup{}
+	for _, m := range modules {
+		wg.Add(1)
+		go func(m *module.IConnectorModule) {
+			defer wg.Done()
+
+			data := (*m).GetConnectorModuleData()
+			problems := validateModule(data, servers)
+			data.Status.ValidationErrors = problems
+			for _, p := range problems {
+				log.Warnf("module %s validation: %s", (*m).GetID(), p)
+			}
+		}(m)
+	}
+
+	wg.Wait()
+}
+
+// moduleHosts returns the servers a module sends to which can be checked, MQTT servers are skipped
+func moduleHosts(data *module.ConnectorModuleData) []string {
+	hosts := make([]string, 0)
+	for _, ds := range data.GetDatastreams() {
+		if len(ds.DatastreamID) > 0 && !isMQTTServer(ds.Host) {
+			hosts = append(hosts, ds.Host)
+		}
+	}
+
+	for _, t := range data.Things {
+		if !isMQTTServer(t.Host) {
+			hosts = append(hosts, t.Host)
+		}
+	}
+
+	return hosts
+}
+
+// checkServers requests the service root of all servers at the same time and returns the
+// error for every server, the error is nil for reachable servers
+func checkServers(hosts []string) map[string]error {
+	unique := make(map[string]bool)
+	for _, host := range hosts {
+		unique[host] = true
+	}
+
+	servers := make(map[string]error)
+	mutex := &sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for host := range unique {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+
+			err := checkServer(host)
+			mutex.Lock()
+			servers[host] = err
+			mutex.Unlock()
+		}(host)
+	}
+
+	wg.Wait()
+	return servers
+}
+
+// validateModule returns the problems found for the Datastreams and Things of a module, servers
+// contains the result of checkServers. The Datastreams are not checked in a dry run as the ids of
+// Datastreams provisioned by the sink do not exist on the server
+func validateModule(data *module.ConnectorModuleData, servers map[string]error) []string {
+	problems := make([]string, 0)
+	reported := make(map[string]bool)
+
+	reachable := func(host string) bool {
+		err := servers[host]
+		if err == nil {
+			return true
+		}
+
+		if !reported[host] {
+			reported[host] = true
+			problems = append(problems, fmt.Sprintf("server %s unreachable: %v", module.RedactURL(host), err))
+		}
+
+		return false
+	}
+
+	for _, ds := range data.GetDatastreams() {
+		if len(ds.DatastreamID) == 0 {
+			problems = append(problems, fmt.Sprintf("stream %s on %s could not be resolved", ds.StreamID, module.RedactURL(ds.Host)))
+			continue
+		}
+
+		if isMQTTServer(ds.Host) || !reachable(ds.Host) || dryRun {
+			continue
+		}
+
+		if err := checkDatastream(ds); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	for _, t := range data.Things {
+		if isMQTTServer(t.Host) || !reachable(t.Host) {
+			continue
+		}
+
+		if _, err := getEntity(t.Host, fmt.Sprintf("Things(%s)", t.ThingID)); err != nil {
+			problems = append(problems, fmt.Sprintf("Thing %s on %s: %v", t.ThingID, module.RedactURL(t.Host), err))
+		}
+	}
+
+	return problems
+}
+
+// checkServer requests the service root of a server
+func checkServer(host string) error {
+	req, _ := http.NewRequest("GET", getHostWithSuffix(host), nil)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := getServerClient(host).Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected StatusCode, expected %v got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// checkDatastream checks if a Datastream exists and belongs to the Thing from the mapping
+func checkDatastream(ds module.DatastreamInfo) error {
+	host := module.RedactURL(ds.Host)
+	if _, err := getEntity(ds.Host, fmt.Sprintf("Datastreams(%s)", ds.DatastreamID)); err != nil {
+		return fmt.Errorf("Datastream %s on %s: %v", ds.DatastreamID, host, err)
+	}
+
+	if len(ds.ThingName) == 0 {
+		return nil
+	}
+
+	thing, err := getEntity(ds.Host, fmt.Sprintf("Datastreams(%s)/Thing", ds.DatastreamID))
+	if err != nil {
+		return fmt.Errorf("Thing of Datastream %s on %s: %v", ds.DatastreamID, host, err)
+	}
+
+	if name, _ := thing["name"].(string); name != ds.ThingName {
+		return fmt.Errorf("Datastream %s on %s belongs to Thing %s, expected %s", ds.DatastreamID, host, name, ds.ThingName)
+	}
+
+	return nil
+}
+
+// getEntity requests a single entity on the server
+func getEntity(host, path string) (map[string]interface{}, error) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s%s", getHostWithSuffix(host), path), nil)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := getServerClient(host).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("not found")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected StatusCode requesting %s, expected %v got %v", path, http.StatusOK, resp.StatusCode)
+	}
+
+	entity := make(map[string]interface{})
+	err = json.NewDecoder(resp.Body).Decode(&entity)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse response for %s: %v", path, err)
+	}
+
+	return entity, nil
+}
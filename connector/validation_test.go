@@ -0,0 +1,102 @@
+package connector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gost/sensorthings-connector/module"
+)
+
+func TestValidateModule(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1.0/"), strings.HasSuffix(r.URL.Path, "Datastreams(1)"), strings.HasSuffix(r.URL.Path, "Things(1)"):
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dead.Close()
+
+	host := server.URL + "/v1.0/"
+	deadHost := dead.URL + "/v1.0/"
+	defer func() { dryRun = false }()
+
+	tests := []struct {
+		name        string
+		dryRun      bool
+		datastreams []module.DatastreamInfo
+		things      []module.ThingInfo
+		problems    []string
+	}{
+		{"existing entities", false,
+			[]module.DatastreamInfo{{Host: host, StreamID: "1", DatastreamID: "1"}},
+			[]module.ThingInfo{{Host: host, ThingID: "1"}},
+			[]string{}},
+		{"missing entities", false,
+			[]module.DatastreamInfo{{Host: host, StreamID: "2", DatastreamID: "2"}},
+			[]module.ThingInfo{{Host: host, ThingID: "2"}},
+			[]string{"Datastream 2 on " + host + ": not found", "Thing 2 on " + host + ": not found"}},
+		{"unresolved stream", false,
+			[]module.DatastreamInfo{{Host: host, StreamID: "resolve:temperature"}},
+			nil,
+			[]string{"stream resolve:temperature on " + host + " could not be resolved"}},
+		{"provisioned in dry run", true,
+			[]module.DatastreamInfo{{Host: host, StreamID: "provision:temperature", DatastreamID: "dry-run-Datastreams-1"}},
+			nil,
+			[]string{}},
+		{"unreachable server", false,
+			[]module.DatastreamInfo{{Host: deadHost, StreamID: "1", DatastreamID: "1"}, {Host: deadHost, StreamID: "2", DatastreamID: "2"}},
+			nil,
+			[]string{"server " + deadHost + " unreachable"}},
+	}
+
+	for _, test := range tests {
+		dryRun = test.dryRun
+		data := module.NewConnectorModuleData("1", "test.so", "test.so", nil, nil, nil)
+		data.Datastreams = test.datastreams
+		if test.things != nil {
+			data.Things = test.things
+		}
+
+		problems := validateModule(data, checkServers(moduleHosts(data)))
+		for i := range problems {
+			// the error of an unreachable server depends on the platform
+			if strings.HasPrefix(problems[i], "server ") {
+				problems[i] = problems[i][:strings.Index(problems[i], " unreachable")+len(" unreachable")]
+			}
+		}
+
+		if !reflect.DeepEqual(problems, test.problems) {
+			t.Errorf("%s: expected problems %v, got %v", test.name, test.problems, problems)
+		}
+	}
+}
+
+func TestCheckServersParallel(t *testing.T) {
+	slow := func() string {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Millisecond * 200)
+		}))
+		t.Cleanup(s.Close)
+		return s.URL + "/v1.0/"
+	}
+
+	// each server takes 200ms to answer, checked one after another this would take 600ms
+	hosts := []string{slow(), slow(), slow()}
+	start := time.Now()
+	servers := checkServers(append(hosts, hosts[0]))
+	if d := time.Since(start); d > time.Millisecond*500 {
+		t.Errorf("expected the servers to be checked at the same time, took %v", d)
+	}
+	if len(servers) != len(hosts) {
+		t.Errorf("expected %v servers to be checked, got %v", len(hosts), len(servers))
+	}
+}
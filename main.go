@@ -20,12 +20,19 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		if err := runBackfillCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	initShutdownListener()
 	initLogRus()
 	initConfig()
 	addLoggingDiscordHook(config.Logging.Discord)
 	startStatusReporter(config.Logging.Status)
-	connector.Start(config.Connector)
+	connector.Start(config)
 }
 
 // initLogRus configures logrus for use in sensorthings-connector
@@ -107,14 +114,35 @@ func startStatusReporter(cfg configuration.StatusConfig) {
 				data := (*m).GetConnectorModuleData()
 				status := data.Status
 				log.WithFields(log.Fields{
-					"Running":          status.Running,
+					"State":            status.State,
 					"Latest GET time":  status.LastGet,
 					"Latest POST time": status.LastPost,
 					"POST success":     status.ObservationsPostedOk,
 					"POST failed":      status.ObservationsPostedFailed,
+					"Outbox pending":   status.OutboxPending,
 					"Errors":           status.ErrorCount,
+					"Warnings":         status.WarningCount,
 				}).Infof("Status report for module %s", data.ModuleFileName)
 			}
+
+			for _, s := range connector.GetServerStatus() {
+				log.WithFields(log.Fields{
+					"Circuit breaker":      s.Breaker,
+					"Consecutive failures": s.ConsecutiveFailures,
+					"Held messages":        s.Held,
+				}).Infof("Status report for server %s", s.Host)
+			}
+
+			for _, q := range connector.GetQueueStatus() {
+				log.WithFields(log.Fields{
+					"Depth":     q.Depth,
+					"Waiting":   q.Waiting,
+					"Capacity":  q.Capacity,
+					"Processed": q.Processed,
+					"Dropped":   q.Dropped,
+					"Spilled":   q.Spilled,
+				}).Infof("Status report for queue %s", q.Host)
+			}
 		}
 	}()
 }
@@ -0,0 +1,260 @@
+package module
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Aggregation functions
+const (
+	AggregateMean  = "mean"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateSum   = "sum"
+	AggregateCount = "count"
+	AggregateLast  = "last"
+)
+
+// Aggregation describes a tumbling window in which the results of a stream are combined into
+// a single observation, the phenomenonTime of the observation is the interval of the window.
+// A window is closed GraceSeconds after its end when no result for a later window arrived
+type Aggregation struct {
+	WindowSeconds int    `json:"windowSeconds"`
+	Function      string `json:"function"`
+	GraceSeconds  int    `json:"graceSeconds"`
+}
+
+// window holds the results of a stream for the current window of an aggregation
+type window struct {
+	host     string
+	streamID string
+	start    time.Time
+	values   []float64
+	count    int
+	last     interface{}
+	feature  *FeatureOfInterest
+	timer    *time.Timer
+}
+
+// validate checks the window size and function of an aggregation
+func (a *Aggregation) validate() error {
+	if a.WindowSeconds <= 0 {
+		return fmt.Errorf("windowSeconds should be larger than 0")
+	}
+
+	if a.GraceSeconds < 0 {
+		return fmt.Errorf("graceSeconds can not be negative")
+	}
+
+	switch a.Function {
+	case AggregateMean, AggregateMin, AggregateMax, AggregateSum, AggregateCount, AggregateLast:
+		return nil
+	}
+
+	return fmt.Errorf("unknown aggregation function %s", a.Function)
+}
+
+// aggregate adds an observation to the window of the stream, when the observation belongs to
+// a later window the observation for the current window is returned. Observations for a window
+// which was already send, also before a restart of the module, are skipped. A backfill b has
+// its own windows which are only closed by later observations and at the end of the backfill
+func (c *ConnectorModuleBase) aggregate(host, streamID string, a *Aggregation, observation Observation, b *Backfill) (*Observation, error) {
+	t, ok := parsePhenomenonTime(observation.PhenomenonTime)
+	if !ok {
+		t = time.Now().UTC()
+	}
+
+	size := time.Second * time.Duration(a.WindowSeconds)
+	start := t.Truncate(size)
+	key := streamKey(host, streamID)
+
+	c.aggMutex.Lock()
+	defer c.aggMutex.Unlock()
+
+	windows := c.windows
+	if b != nil {
+		windows = b.windows
+	}
+
+	var result *Observation
+	w, ok := windows[key]
+	if ok && start.Before(w.start) {
+		return nil, fmt.Errorf("skipped observation for %s, phenomenonTime %s is before the current aggregation window %s", streamID, observation.PhenomenonTime, w.start.Format(time.RFC3339))
+	}
+
+	if ok && start.After(w.start) {
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+		result = w.observation(a)
+		ok = false
+	}
+
+	if !ok && b != nil {
+		w = &window{host: host, streamID: streamID, start: start, values: make([]float64, 0)}
+		windows[key] = w
+	} else if !ok {
+		if posted := c.postedUntil(host, streamID); c.CheckPhenomenonTime && !start.Add(size).After(posted) {
+			return result, nil
+		}
+
+		w = &window{host: host, streamID: streamID, start: start, values: make([]float64, 0)}
+		w.timer = time.AfterFunc(a.closeDelay(start), func() { c.closeWindow(key, w, a) })
+		windows[key] = w
+	}
+
+	w.count++
+	w.last = observation.Result
+	w.feature = observation.FeatureOfInterest
+	if v, isNumber := toFloat(observation.Result); isNumber {
+		w.values = append(w.values, v)
+	}
+
+	return result, nil
+}
+
+// closeDelay returns the time until a window starting at start should be closed, a window
+// for results which arrive late is kept open for at least the grace period
+func (a *Aggregation) closeDelay(start time.Time) time.Duration {
+	grace := time.Second * time.Duration(a.GraceSeconds)
+	if a.GraceSeconds == 0 {
+		grace = time.Second * time.Duration(a.WindowSeconds)
+	}
+
+	end := start.Add(time.Second * time.Duration(a.WindowSeconds))
+	if d := time.Until(end); d > 0 {
+		return d + grace
+	}
+
+	return grace
+}
+
+// closeWindow sends the observation for a window which did not receive a result for a later
+// window before the end of its grace period
+func (c *ConnectorModuleBase) closeWindow(key string, w *window, a *Aggregation) {
+	c.aggMutex.Lock()
+	if c.windows[key] != w {
+		c.aggMutex.Unlock()
+		return
+	}
+
+	delete(c.windows, key)
+	c.aggMutex.Unlock()
+
+	if o := w.observation(a); o != nil && !c.isStopped() {
+		c.fanOut(w.host, w.streamID, *o, nil)
+	}
+}
+
+// postedUntil returns the phenomenonTime up to which observations were posted for a stream
+// to all its destinations, a zero time is returned when a destination has no last post
+func (c *ConnectorModuleBase) postedUntil(host, streamID string) time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var until time.Time
+	for i, d := range c.streamDestinations(host, streamID) {
+		last, ok := c.lastPosts[streamKey(d.host, c.cachedDatastreamID(d.streamID))]
+		if !ok {
+			return time.Time{}
+		}
+
+		if i == 0 || last.phenomenonTime.Before(until) {
+			until = last.phenomenonTime
+		}
+	}
+
+	return until
+}
+
+// observation creates the observation for the window
+func (w *window) observation(a *Aggregation) *Observation {
+	end := w.start.Add(time.Second * time.Duration(a.WindowSeconds))
+	o := &Observation{
+		PhenomenonTime: fmt.Sprintf("%s/%s", w.start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339)),
+		Parameters: map[string]interface{}{
+			"aggregation": a.Function,
+			"sampleCount": w.count,
+		},
+		FeatureOfInterest: w.feature,
+	}
+
+	switch a.Function {
+	case AggregateCount:
+		o.Result = w.count
+	case AggregateLast:
+		o.Result = w.last
+	default:
+		if len(w.values) == 0 {
+			return nil
+		}
+
+		o.Result = aggregateValues(a.Function, w.values)
+	}
+
+	return o
+}
+
+// aggregateValues applies an aggregation function to a list of numbers
+func aggregateValues(function string, values []float64) float64 {
+	r := values[0]
+	if function == AggregateMin || function == AggregateMax {
+		for _, v := range values[1:] {
+			if function == AggregateMin {
+				r = math.Min(r, v)
+			} else {
+				r = math.Max(r, v)
+			}
+		}
+
+		return r
+	}
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+
+	if function == AggregateMean {
+		return sum / float64(len(values))
+	}
+
+	return sum
+}
+
+// Flush sends the observations for all partial aggregation windows, it is called when the module is stopped
+func (c *ConnectorModuleBase) Flush() {
+	c.aggMutex.Lock()
+	windows := c.windows
+	c.windows = make(map[string]*window)
+	c.aggMutex.Unlock()
+
+	for key, w := range windows {
+		w.timer.Stop()
+		a := c.aggregations[key]
+		if o := w.observation(a); o != nil {
+			c.fanOut(w.host, w.streamID, *o, nil)
+		}
+	}
+}
+
+// flushBackfill sends the observations for the windows of a backfill which ended before the
+// end of the backfill, the last partial window is left to the observations of the schedule
+func (c *ConnectorModuleBase) flushBackfill(b *Backfill) {
+	c.aggMutex.Lock()
+	windows := b.windows
+	b.windows = make(map[string]*window)
+	c.aggMutex.Unlock()
+
+	for key, w := range windows {
+		a := c.aggregations[key]
+		if w.start.Add(time.Second * time.Duration(a.WindowSeconds)).After(b.To) {
+			continue
+		}
+
+		if o := w.observation(a); o != nil {
+			c.fanOut(w.host, w.streamID, *o, b)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package module
+
+import (
+	"testing"
+	"time"
+)
+
+func newAggregationTestBase() (*ConnectorModuleBase, chan ObservationMessage) {
+	observations := make(chan ObservationMessage, 10)
+	errors := make(chan ErrorMessage, 10)
+	c := &ConnectorModuleBase{}
+	c.SetConnectorModuleData(NewConnectorModuleData("1", "test.so", "test.so", &observations, nil, &errors))
+	return c, observations
+}
+
+func TestAggregate(t *testing.T) {
+	type sample struct {
+		time   string
+		result interface{}
+	}
+
+	type emitted struct {
+		phenomenonTime string
+		result         interface{}
+		count          int
+	}
+
+	tests := []struct {
+		name     string
+		function string
+		posted   string
+		samples  []sample
+		emitted  []emitted
+		errors   int
+	}{
+		{"mean", AggregateMean, "", []sample{{"2020-01-01T00:00:10Z", 1.0}, {"2020-01-01T00:00:50Z", 2.0}, {"2020-01-01T00:01:00Z", 9.0}},
+			[]emitted{{"2020-01-01T00:00:00Z/2020-01-01T00:01:00Z", 1.5, 2}}, 0},
+		{"min", AggregateMin, "", []sample{{"2020-01-01T00:00:10Z", 3.0}, {"2020-01-01T00:00:20Z", -1.0}, {"2020-01-01T00:02:00Z", 9.0}},
+			[]emitted{{"2020-01-01T00:00:00Z/2020-01-01T00:01:00Z", -1.0, 2}}, 0},
+		{"max", AggregateMax, "", []sample{{"2020-01-01T00:00:10Z", 3.0}, {"2020-01-01T00:00:20Z", -1.0}, {"2020-01-01T00:02:00Z", 9.0}},
+			[]emitted{{"2020-01-01T00:00:00Z/2020-01-01T00:01:00Z", 3.0, 2}}, 0},
+		{"sum", AggregateSum, "", []sample{{"2020-01-01T00:00:10Z", 3.0}, {"2020-01-01T00:00:20Z", 4}, {"2020-01-01T00:02:00Z", 9.0}},
+			[]emitted{{"2020-01-01T00:00:00Z/2020-01-01T00:01:00Z", 7.0, 2}}, 0},
+		{"count", AggregateCount, "", []sample{{"2020-01-01T00:00:10Z", "a"}, {"2020-01-01T00:00:20Z", "b"}, {"2020-01-01T00:01:00Z", "c"}},
+			[]emitted{{"2020-01-01T00:00:00Z/2020-01-01T00:01:00Z", 2, 2}}, 0},
+		{"last", AggregateLast, "", []sample{{"2020-01-01T00:00:10Z", "a"}, {"2020-01-01T00:00:20Z", "b"}, {"2020-01-01T00:01:00Z", "c"}},
+			[]emitted{{"2020-01-01T00:00:00Z/2020-01-01T00:01:00Z", "b", 2}}, 0},
+		{"multiple windows", AggregateMean, "", []sample{{"2020-01-01T00:00:10Z", 1.0}, {"2020-01-01T00:01:10Z", 2.0}, {"2020-01-01T00:03:10Z", 3.0}},
+			[]emitted{{"2020-01-01T00:00:00Z/2020-01-01T00:01:00Z", 1.0, 1}, {"2020-01-01T00:01:00Z/2020-01-01T00:02:00Z", 2.0, 1}}, 0},
+		{"no numeric results", AggregateMean, "", []sample{{"2020-01-01T00:00:10Z", "a"}, {"2020-01-01T00:01:10Z", 2.0}},
+			[]emitted{}, 0},
+		{"before current window", AggregateMean, "", []sample{{"2020-01-01T00:01:10Z", 1.0}, {"2020-01-01T00:00:10Z", 2.0}},
+			[]emitted{}, 1},
+		{"window already send", AggregateMean, "2020-01-01T00:01:00Z", []sample{{"2020-01-01T00:00:10Z", 1.0}, {"2020-01-01T00:00:20Z", 1.0}, {"2020-01-01T00:01:10Z", 2.0}, {"2020-01-01T00:02:10Z", 3.0}},
+			[]emitted{{"2020-01-01T00:01:00Z/2020-01-01T00:02:00Z", 2.0, 1}}, 0},
+	}
+
+	for _, test := range tests {
+		c, _ := newAggregationTestBase()
+		a := &Aggregation{WindowSeconds: 60, Function: test.function, GraceSeconds: 3600}
+		c.aggregations[streamKey("http://h/", "1")] = a
+		if len(test.posted) > 0 {
+			posted, _ := parsePhenomenonTime(test.posted)
+			c.lastPosts[streamKey("http://h/", "1")] = newPostState("http://h/", "1", 0, posted, time.Now())
+		}
+
+		got := make([]*Observation, 0)
+		errors := 0
+		for _, s := range test.samples {
+			o, err := c.aggregate("http://h/", "1", a, Observation{PhenomenonTime: s.time, Result: s.result}, nil)
+			if err != nil {
+				errors++
+			}
+			if o != nil {
+				got = append(got, o)
+			}
+		}
+		c.Flush()
+
+		if errors != test.errors {
+			t.Errorf("%s: expected %v errors, got %v", test.name, test.errors, errors)
+		}
+		if len(got) != len(test.emitted) {
+			t.Errorf("%s: expected %v observations, got %v", test.name, len(test.emitted), len(got))
+			continue
+		}
+
+		for i, e := range test.emitted {
+			if got[i].PhenomenonTime != e.phenomenonTime || got[i].Result != e.result || got[i].Parameters["sampleCount"] != e.count {
+				t.Errorf("%s: expected %v %v (%v samples), got %v %v (%v samples)", test.name, e.phenomenonTime, e.result, e.count, got[i].PhenomenonTime, got[i].Result, got[i].Parameters["sampleCount"])
+			}
+		}
+	}
+}
+
+func TestAggregationCloseDelay(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		a     Aggregation
+		start time.Time
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"past window default grace", Aggregation{WindowSeconds: 60}, now.Add(-time.Hour), time.Minute, time.Minute},
+		{"past window with grace", Aggregation{WindowSeconds: 60, GraceSeconds: 5}, now.Add(-time.Hour), time.Second * 5, time.Second * 5},
+		{"current window", Aggregation{WindowSeconds: 60, GraceSeconds: 5}, now.Add(-time.Second * 30), time.Second * 34, time.Second * 35},
+	}
+
+	for _, test := range tests {
+		if d := test.a.closeDelay(test.start); d < test.min || d > test.max {
+			t.Errorf("%s: expected delay between %v and %v, got %v", test.name, test.min, test.max, d)
+		}
+	}
+}
+
+func TestAggregationWindowTimer(t *testing.T) {
+	c, observations := newAggregationTestBase()
+	a := &Aggregation{WindowSeconds: 60, Function: AggregateMax, GraceSeconds: 1}
+	c.aggregations[streamKey("http://h/", "1")] = a
+
+	o, err := c.aggregate("http://h/", "1", a, Observation{PhenomenonTime: "2020-01-01T00:00:10Z", Result: 4.0}, nil)
+	if o != nil || err != nil {
+		t.Fatalf("expected no observation before the window is closed, got %v %v", o, err)
+	}
+
+	select {
+	case msg := <-observations:
+		if msg.Observation.PhenomenonTime != "2020-01-01T00:00:00Z/2020-01-01T00:01:00Z" || msg.Observation.Result != 4.0 {
+			t.Errorf("unexpected observation for closed window: %+v", msg.Observation)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("window not closed after its grace period")
+	}
+
+	// a late result for the closed window does not send the window again
+	c.aggregate("http://h/", "1", a, Observation{PhenomenonTime: "2020-01-01T00:00:20Z", Result: 5.0}, nil)
+	c.Flush()
+	select {
+	case msg := <-observations:
+		t.Errorf("window send twice: %+v", msg.Observation)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestAggregationBackfill(t *testing.T) {
+	c, observations := newAggregationTestBase()
+	a := &Aggregation{WindowSeconds: 60, Function: AggregateSum}
+	c.aggregations[streamKey("http://h/", "1")] = a
+
+	// the live stream already posted a later window, the backfill windows are send anyway
+	posted, _ := parsePhenomenonTime("2020-01-01T01:00:00Z")
+	c.lastPosts[streamKey("http://h/", "1")] = newPostState("http://h/", "1", 0, posted, time.Now())
+
+	to, _ := parsePhenomenonTime("2020-01-01T00:02:30Z")
+	b := &Backfill{
+		BackfillRequest: BackfillRequest{To: to},
+		module:          c,
+		status:          &BackfillStatus{},
+		lastPosts:       make(map[string]*postState),
+		qualityStates:   make(map[string]*qualityState),
+		windows:         make(map[string]*window),
+	}
+
+	samples := []string{"2020-01-01T00:00:10Z", "2020-01-01T00:00:20Z", "2020-01-01T00:01:10Z", "2020-01-01T00:02:10Z"}
+	emitted := make([]*Observation, 0)
+	for _, s := range samples {
+		o, err := c.aggregate("http://h/", "1", a, Observation{PhenomenonTime: s, Result: 1.0}, b)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if o != nil {
+			emitted = append(emitted, o)
+		}
+	}
+
+	if len(emitted) != 2 || emitted[0].Result != 2.0 || emitted[1].Result != 1.0 {
+		t.Fatalf("expected 2 closed backfill windows, got %v", emitted)
+	}
+	if len(c.windows) != 0 || b.windows[streamKey("http://h/", "1")].timer != nil {
+		t.Errorf("backfill windows should not be live windows or have a timer")
+	}
+
+	// the last window ends after the end of the backfill and is not send
+	c.flushBackfill(b)
+	select {
+	case msg := <-observations:
+		t.Errorf("partial backfill window send: %+v", msg.Observation)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -0,0 +1,212 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// States of a backfill
+const (
+	BackfillRunning   = "running"
+	BackfillDone      = "done"
+	BackfillFailed    = "failed"
+	BackfillCancelled = "cancelled"
+)
+
+// IBackfiller is implemented by modules which can request readings between two timestamps,
+// readings should be send using the SendObservation function of the given Backfill
+type IBackfiller interface {
+	Backfill(ctx context.Context, backfill *Backfill) error
+}
+
+// IBackfillModule is implemented by ConnectorModuleBase and used by the connector to start a backfill
+type IBackfillModule interface {
+	CanBackfill() bool
+	StartBackfill(request BackfillRequest) error
+	CatchUp(maxWindow, minGap time.Duration) error
+	PrepareCatchUp(maxWindow, minGap time.Duration) error
+}
+
+// BackfillRequest describes the time range and the streams to backfill, when streams is
+// empty all streams of the module are backfilled
+type BackfillRequest struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Streams []string  `json:"streams"`
+}
+
+// BackfillStatus contains the progress of the last backfill of a module
+type BackfillStatus struct {
+	State        string   `json:"state"`
+	From         string   `json:"from"`
+	To           string   `json:"to"`
+	Streams      []string `json:"streams"`
+	Done         int      `json:"done"`
+	Total        int      `json:"total"`
+	Observations int64    `json:"observations"`
+	CatchUp      bool     `json:"catchUp"`
+	Error        string   `json:"error,omitempty"`
+	StartTime    string   `json:"startTime"`
+	EndTime      string   `json:"endTime,omitempty"`
+}
+
+// Backfill is passed to the Backfill function of a module, observations send with it go through
+// the same transforms, quality rules, aggregation, duplicate checks and delivery as the observations
+// of the schedule. The quality state and aggregation windows of a backfill are kept separately
+type Backfill struct {
+	BackfillRequest
+	module        *ConnectorModuleBase
+	ctx           context.Context
+	cancel        context.CancelFunc
+	status        *BackfillStatus
+	streams       map[string]bool
+	lastPosts     map[string]*postState
+	after         map[string]time.Time
+	qualityStates map[string]*qualityState
+	windows       map[string]*window
+}
+
+// CanBackfill returns true when the module has a Backfiller
+func (c *ConnectorModuleBase) CanBackfill() bool {
+	return c.Backfiller != nil
+}
+
+// StartBackfill starts a backfill for the given request in the background, only one backfill
+// can run at a time for a running module
+func (c *ConnectorModuleBase) StartBackfill(request BackfillRequest) error {
+	return c.startBackfill(request, nil)
+}
+
+// startBackfill starts a backfill, observations for a server and Datastream in after
+// are only send when they are newer than the given time
+func (c *ConnectorModuleBase) startBackfill(request BackfillRequest, after map[string]time.Time) error {
+	if c.Backfiller == nil {
+		return fmt.Errorf("module %s does not support backfill", c.GetID())
+	}
+
+	if !request.From.Before(request.To) {
+		return fmt.Errorf("from should be before to")
+	}
+
+	if request.To.After(time.Now()) {
+		request.To = time.Now()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.scheduler == nil || c.isStopped() {
+		return fmt.Errorf("module %s is not running", c.GetID())
+	}
+
+	if c.backfill != nil {
+		return fmt.Errorf("a backfill is already running for module %s", c.GetID())
+	}
+
+	b := &Backfill{
+		BackfillRequest: request,
+		module:          c,
+		streams:         make(map[string]bool),
+		lastPosts:       make(map[string]*postState),
+		after:           after,
+		qualityStates:   make(map[string]*qualityState),
+		windows:         make(map[string]*window),
+		status: &BackfillStatus{
+			State:     BackfillRunning,
+			From:      request.From.UTC().Format(time.RFC3339),
+			To:        request.To.UTC().Format(time.RFC3339),
+			Streams:   request.Streams,
+			CatchUp:   after != nil,
+			StartTime: time.Now().UTC().String(),
+		},
+	}
+
+	for _, s := range request.Streams {
+		b.streams[s] = true
+	}
+
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	c.backfill = b
+	c.ModuleData.Status.Backfill = b.status
+
+	go c.runBackfill(b)
+	return nil
+}
+
+// CancelBackfill cancels the running backfill of the module
+func (c *ConnectorModuleBase) CancelBackfill() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.backfill != nil {
+		c.backfill.cancel()
+	}
+}
+
+func (c *ConnectorModuleBase) runBackfill(b *Backfill) {
+	err := c.Backfiller.Backfill(b.ctx, b)
+	if err == nil && b.ctx.Err() == nil {
+		c.flushBackfill(b)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch {
+	case b.ctx.Err() != nil:
+		b.status.State = BackfillCancelled
+	case err != nil:
+		b.status.State = BackfillFailed
+		b.status.Error = err.Error()
+	default:
+		b.status.State = BackfillDone
+	}
+
+	b.status.EndTime = time.Now().UTC().String()
+	b.cancel()
+	c.backfill = nil
+}
+
+// Includes returns true when the stream with the given streamId should be backfilled, streams
+// are selected by the streamId in the module config or the id of a Datastream they are send to
+func (b *Backfill) Includes(streamID string) bool {
+	if len(b.streams) == 0 {
+		return true
+	}
+
+	for _, id := range b.module.streamIdentifiers(streamID) {
+		if b.streams[id] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Progress sets the number of done and total steps of the backfill, for example the
+// number of requests to the vendor API
+func (b *Backfill) Progress(done, total int) {
+	b.module.mutex.Lock()
+	defer b.module.mutex.Unlock()
+
+	b.status.Done = done
+	b.status.Total = total
+}
+
+// SendObservation sends a backfilled observation, observations for streams which are not
+// included in the backfill are ignored
+func (b *Backfill) SendObservation(host, streamID string, observation Observation) {
+	c := b.module
+	if b.ctx.Err() != nil || c.isStopped() || !b.Includes(streamID) {
+		return
+	}
+
+	c.sendStream(c.ModuleData.ResolveServer(host), streamID, observation, b)
+}
+
+// sent is called when an observation of the backfill is send to the connector
+func (b *Backfill) sent() {
+	atomic.AddInt64(&b.status.Observations, 1)
+}
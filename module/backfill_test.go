@@ -0,0 +1,120 @@
+package module
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// rangeBackfiller sends a reading for every stream and every hour between from and to of the backfill
+type rangeBackfiller struct {
+	streamIDs []string
+}
+
+func (r rangeBackfiller) Backfill(ctx context.Context, b *Backfill) error {
+	for t := b.From; t.Before(b.To); t = t.Add(time.Hour) {
+		for _, streamID := range r.streamIDs {
+			b.SendObservation("http://gost/v1.0/", streamID, Observation{Result: 1, PhenomenonTime: t.UTC().Format(time.RFC3339)})
+		}
+	}
+
+	return nil
+}
+
+func newBackfillTestBase(t *testing.T) (*ConnectorModuleBase, *blockingFetcher) {
+	c, f := newLifecycleTestBase(false)
+	off := false
+	c.Schedule.RunOnStart = &off
+	source := `{"mappings":[{"server":"http://gost/v1.0/","streams":[` +
+		`{"streamId":"1"},` +
+		`{"streamId":"2","destinations":[{"streamId":"5"}]},` +
+		`{"datastreamRef":{"thing":"thing","name":"temperature"}}]}]}`
+	if _, err := c.parseMappings([]byte(source)); err != nil {
+		t.Fatalf("unable to parse mappings: %v", err)
+	}
+
+	c.datastreamRefs["resolve:http://gost/v1.0//thing/temperature"].id = "42"
+	return c, f
+}
+
+func TestBackfillIncludes(t *testing.T) {
+	c, _ := newBackfillTestBase(t)
+
+	tests := []struct {
+		name     string
+		streams  []string
+		streamID string
+		included bool
+	}{
+		{"all streams", []string{}, "1", true},
+		{"streamId", []string{"1"}, "1", true},
+		{"other streamId", []string{"2"}, "1", false},
+		{"fan-out by streamId", []string{"2"}, "fanout:0/1", true},
+		{"fan-out by destination", []string{"5"}, "fanout:0/1", true},
+		{"fan-out not selected", []string{"1"}, "fanout:0/1", false},
+		{"reference by Datastream id", []string{"42"}, "resolve:http://gost/v1.0//thing/temperature", true},
+		{"reference not selected", []string{"1"}, "resolve:http://gost/v1.0//thing/temperature", false},
+	}
+
+	for _, test := range tests {
+		b := &Backfill{module: c, streams: make(map[string]bool)}
+		for _, s := range test.streams {
+			b.streams[s] = true
+		}
+
+		if got := b.Includes(test.streamID); got != test.included {
+			t.Errorf("%s: expected %v, got %v", test.name, test.included, got)
+		}
+	}
+}
+
+func TestStartBackfill(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour * 3)
+
+	c, f := newBackfillTestBase(t)
+	defer close(f.release)
+
+	if err := c.StartBackfill(BackfillRequest{From: from, To: to}); err == nil {
+		t.Errorf("expected an error for a module without Backfiller")
+	}
+
+	c.Backfiller = rangeBackfiller{streamIDs: []string{"1"}}
+	if err := c.StartBackfill(BackfillRequest{From: from, To: to}); err == nil {
+		t.Errorf("expected an error for a module which was never started")
+	}
+
+	if err := c.Start(false); err != nil {
+		t.Fatalf("unable to start: %v", err)
+	}
+	defer c.Stop()
+
+	if err := c.StartBackfill(BackfillRequest{From: to, To: from}); err == nil {
+		t.Errorf("expected an error when from is after to")
+	}
+
+	if err := c.StartBackfill(BackfillRequest{From: from, To: to, Streams: []string{"1"}}); err != nil {
+		t.Fatalf("unable to start backfill: %v", err)
+	}
+
+	status := waitForBackfill(c)
+	if status.State != BackfillDone || status.Observations != 3 {
+		t.Errorf("expected a finished backfill with 3 observations, got %+v", status)
+	}
+}
+
+// waitForBackfill waits until the backfill of the module is no longer running and returns its status
+func waitForBackfill(c *ConnectorModuleBase) BackfillStatus {
+	var status BackfillStatus
+	for i := 0; i < 50; i++ {
+		time.Sleep(time.Millisecond * 20)
+		c.mutex.Lock()
+		status = *c.ModuleData.Status.Backfill
+		c.mutex.Unlock()
+		if status.State != BackfillRunning {
+			break
+		}
+	}
+
+	return status
+}
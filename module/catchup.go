@@ -0,0 +1,129 @@
+package module
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultCatchUpWindow = time.Hour * 24
+)
+
+// catchUp is a catch-up backfill which is prepared before the module is started
+type catchUp struct {
+	request BackfillRequest
+	after   map[string]time.Time
+}
+
+// CatchUp requests the phenomenonTime of the latest Observation of every Datastream of the module
+// and backfills the readings which are missing since the oldest of them. Datastreams without
+// Observations within maxWindow are left out of the start of the backfill, it starts at most
+// maxWindow ago and is not started when the largest gap is smaller than minGap
+func (c *ConnectorModuleBase) CatchUp(maxWindow, minGap time.Duration) error {
+	if err := c.PrepareCatchUp(maxWindow, minGap); err != nil {
+		return err
+	}
+
+	return c.startCatchUp()
+}
+
+// PrepareCatchUp requests the phenomenonTime of the latest Observation of every Datastream like
+// CatchUp but only starts the backfill when the module is started. It should be called before
+// Start so observations of the first fetch can not hide the gap
+func (c *ConnectorModuleBase) PrepareCatchUp(maxWindow, minGap time.Duration) error {
+	c.mutex.Lock()
+	c.catchUp = nil
+	c.mutex.Unlock()
+
+	if c.Backfiller == nil || c.ModuleData.SensorThings == nil {
+		return nil
+	}
+
+	if maxWindow <= 0 {
+		maxWindow = defaultCatchUpWindow
+	}
+
+	now := time.Now()
+	after := make(map[string]time.Time)
+	var from, oldest time.Time
+	stale := 0
+	for _, ds := range c.ModuleData.GetDatastreams() {
+		if len(ds.DatastreamID) == 0 {
+			continue
+		}
+
+		latest, err := c.ModuleData.SensorThings.LatestPhenomenonTime(ds.Host, ds.DatastreamID)
+		if err != nil {
+			c.SendWarning(fmt.Errorf("unable to get latest Observation of Datastream %s on %s, skipped in catch-up: %v", ds.DatastreamID, RedactURL(ds.Host), err))
+			continue
+		}
+
+		t, ok := parsePhenomenonTime(latest)
+		if !ok {
+			continue
+		}
+
+		after[streamKey(ds.Host, ds.DatastreamID)] = t
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+
+		// a Datastream without Observations within maxWindow does not widen the backfill
+		// of the Datastreams with recent data
+		if t.Before(now.Add(-maxWindow)) {
+			stale++
+			continue
+		}
+
+		if from.IsZero() || t.Before(from) {
+			from = t
+		}
+	}
+
+	if oldest.IsZero() {
+		return nil
+	}
+
+	if stale > 0 {
+		c.SendWarning(fmt.Errorf("%v Datastreams have no Observations since %s which is before the catch-up window of %v, their gap is not fully backfilled", stale, oldest.UTC().Format(time.RFC3339), maxWindow))
+	}
+
+	if from.IsZero() {
+		from = now.Add(-maxWindow)
+	}
+
+	if now.Sub(from) < minGap {
+		return nil
+	}
+
+	c.mutex.Lock()
+	c.catchUp = &catchUp{request: BackfillRequest{From: from, To: now}, after: after}
+	c.mutex.Unlock()
+	return nil
+}
+
+// startCatchUp starts the prepared catch-up, the readings until the latest Observations were
+// requested are backfilled and the first fetch of the module sends the newer readings
+func (c *ConnectorModuleBase) startCatchUp() error {
+	c.mutex.Lock()
+	pending := c.catchUp
+	c.catchUp = nil
+	c.mutex.Unlock()
+
+	if pending == nil {
+		return nil
+	}
+
+	return c.startBackfill(pending.request, pending.after)
+}
+
+// afterTime returns the phenomenonTime after which observations of a catch-up are send for
+// a server and Datastream
+func (b *Backfill) afterTime(key string) (time.Time, bool) {
+	if b == nil {
+		return time.Time{}, false
+	}
+
+	t, ok := b.after[key]
+	return t, ok
+}
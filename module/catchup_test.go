@@ -0,0 +1,123 @@
+package module
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// latestClient returns the phenomenonTime of the latest Observation of a Datastream from
+// latest, Datastreams which are not in latest fail
+type latestClient struct {
+	latest map[string]time.Time
+}
+
+func (l latestClient) ProvisionDatastream(host string, thing ThingDefinition, datastream DatastreamDefinition) (string, error) {
+	return "", fmt.Errorf("not supported")
+}
+
+func (l latestClient) ResolveDatastream(host string, reference DatastreamReference) (string, error) {
+	return "", fmt.Errorf("not supported")
+}
+
+func (l latestClient) LatestPhenomenonTime(host, datastreamID string) (string, error) {
+	t, ok := l.latest[datastreamID]
+	if !ok {
+		return "", fmt.Errorf("unavailable")
+	}
+
+	return t.UTC().Format(time.RFC3339Nano), nil
+}
+
+func newCatchUpTestBase(latest map[string]time.Time, streamIDs ...string) (*ConnectorModuleBase, *blockingFetcher) {
+	c, f := newLifecycleTestBase(false)
+	off := false
+	c.Schedule.RunOnStart = &off
+	c.Backfiller = rangeBackfiller{streamIDs: streamIDs}
+	c.ModuleData.SensorThings = latestClient{latest: latest}
+	for _, id := range streamIDs {
+		c.ModuleData.Datastreams = append(c.ModuleData.Datastreams, DatastreamInfo{Host: "http://gost/v1.0/", StreamID: id, DatastreamID: id})
+	}
+
+	return c, f
+}
+
+func TestPrepareCatchUp(t *testing.T) {
+	now := time.Now()
+	hoursAgo := func(h int) time.Time { return now.Add(-time.Hour * time.Duration(h)) }
+
+	tests := []struct {
+		name     string
+		latest   map[string]time.Time
+		from     time.Time
+		after    []string
+		warnings int
+	}{
+		{"gap smaller than minGap", map[string]time.Time{"1": now.Add(-time.Minute), "2": now.Add(-time.Minute * 2)}, time.Time{}, nil, 0},
+		{"oldest Datastream", map[string]time.Time{"1": hoursAgo(2), "2": hoursAgo(5)}, hoursAgo(5), []string{"1", "2"}, 0},
+		{"stale Datastream", map[string]time.Time{"1": hoursAgo(2), "2": hoursAgo(100)}, hoursAgo(2), []string{"1", "2"}, 1},
+		{"only stale Datastreams", map[string]time.Time{"1": hoursAgo(50), "2": hoursAgo(100)}, hoursAgo(24), []string{"1", "2"}, 1},
+		{"failing Datastream", map[string]time.Time{"1": hoursAgo(2)}, hoursAgo(2), []string{"1"}, 1},
+		{"no Observations", map[string]time.Time{}, time.Time{}, nil, 2},
+	}
+
+	for _, test := range tests {
+		c, _ := newCatchUpTestBase(test.latest, "1", "2")
+		if err := c.PrepareCatchUp(time.Hour*24, time.Hour); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if warnings := len(*c.ModuleData.ErrorChannel); warnings != test.warnings {
+			t.Errorf("%s: expected %v warnings, got %v", test.name, test.warnings, warnings)
+		}
+
+		if test.from.IsZero() {
+			if c.catchUp != nil {
+				t.Errorf("%s: expected no catch-up, got %+v", test.name, c.catchUp.request)
+			}
+			continue
+		}
+
+		if c.catchUp == nil {
+			t.Errorf("%s: expected a catch-up", test.name)
+			continue
+		}
+
+		// the clamped start is taken from the time of PrepareCatchUp
+		if d := c.catchUp.request.From.Sub(test.from); d < -time.Second || d > time.Second {
+			t.Errorf("%s: expected catch-up from %v, got %v", test.name, test.from, c.catchUp.request.From)
+		}
+		if len(c.catchUp.after) != len(test.after) {
+			t.Errorf("%s: expected latest Observations of %v, got %v", test.name, test.after, c.catchUp.after)
+		}
+		for _, id := range test.after {
+			if after, ok := c.catchUp.after[streamKey("http://gost/v1.0/", id)]; !ok || !after.Equal(test.latest[id]) {
+				t.Errorf("%s: expected Datastream %s after %v, got %v", test.name, id, test.latest[id], after)
+			}
+		}
+	}
+}
+
+func TestCatchUpAfter(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	latest := map[string]time.Time{"1": now.Add(-time.Hour * 5), "2": now.Add(-time.Hour * 2)}
+
+	c, f := newCatchUpTestBase(latest, "1", "2")
+	defer close(f.release)
+
+	if err := c.PrepareCatchUp(time.Hour*24, time.Hour); err != nil {
+		t.Fatalf("unable to prepare catch-up: %v", err)
+	}
+	if err := c.Start(false); err != nil {
+		t.Fatalf("unable to start: %v", err)
+	}
+	defer c.Stop()
+
+	// hourly readings are send from 5 hours ago until now, only the ones newer than the latest
+	// Observation of their Datastream are posted: 5 for Datastream 1 and 2 for Datastream 2
+	status := waitForBackfill(c)
+	if !status.CatchUp || status.State != BackfillDone || status.Observations != 7 {
+		t.Errorf("expected a finished catch-up with 7 observations, got %+v", status)
+	}
+}
@@ -0,0 +1,46 @@
+package module
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFanOutDuplicates(t *testing.T) {
+	c, observations := newAggregationTestBase()
+	c.AllowDuplicateResults = false
+	source := `{"mappings":[{"deviceKey":"d1","server":"http://gost/v1.0/","streams":[{"fieldKey":"t","streamId":"1",` +
+		`"destinations":[{"streamId":"5"},{"server":"http://other/v1.0/","streamId":"7"}]}]}]}`
+	if _, err := c.parseMappings([]byte(source)); err != nil {
+		t.Fatalf("unable to parse mappings: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		streamID string
+		result   interface{}
+		sent     []string
+	}{
+		{"first result", "fanout:0/0", 21.0, []string{"http://gost/v1.0/|1", "http://gost/v1.0/|5", "http://other/v1.0/|7"}},
+		{"duplicate result", "fanout:0/0", 21.0, []string{}},
+		{"changed result", "fanout:0/0", 22.0, []string{"http://gost/v1.0/|1", "http://gost/v1.0/|5", "http://other/v1.0/|7"}},
+		{"result send to a destination", "5", 23.0, []string{"http://gost/v1.0/|5"}},
+		{"duplicate of a single destination", "fanout:0/0", 23.0, []string{"http://gost/v1.0/|1", "http://other/v1.0/|7"}},
+	}
+
+	// duplicates are checked for every destination on its own
+	for _, test := range tests {
+		c.SendObservation("http://gost/v1.0/", test.streamID, Observation{Result: test.result})
+
+		sent := make([]string, 0)
+		for len(observations) > 0 {
+			msg := <-observations
+			sent = append(sent, streamKey(msg.Host, msg.DatastreamID))
+		}
+
+		sort.Strings(sent)
+		if !reflect.DeepEqual(sent, test.sent) {
+			t.Errorf("%s: expected observations for %v, got %v", test.name, test.sent, sent)
+		}
+	}
+}
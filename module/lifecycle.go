@@ -0,0 +1,131 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const defaultStopTimeout = 30
+
+// States of a module
+const (
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateStopping = "stopping"
+	StateStopped  = "stopped"
+	StateFailed   = "failed"
+)
+
+// Start starts the schedule of the module which calls the Fetch function of the Fetcher and
+// the catch-up prepared by PrepareCatchUp, the context passed to Fetch and returned by Context
+// is cancelled when the module is stopped
+func (c *ConnectorModuleBase) Start(onStartup bool) error {
+	if c.Fetcher == nil {
+		return fmt.Errorf("module %s has no Fetcher", c.GetID())
+	}
+
+	c.mutex.Lock()
+	if c.scheduler != nil {
+		c.mutex.Unlock()
+		return nil
+	}
+
+	s, err := c.newScheduler()
+	if err != nil {
+		c.mutex.Unlock()
+		return err
+	}
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.scheduler = s
+	c.startRefresh(c.ctx)
+	atomic.StoreInt32(&c.stopped, 0)
+
+	runOnStart := c.Schedule.RunOnStart == nil || *c.Schedule.RunOnStart
+	go c.runSchedule(c.ctx, s, runOnStart)
+	c.mutex.Unlock()
+
+	if err := c.startCatchUp(); err != nil {
+		c.ModuleData.AddWarning(fmt.Errorf("catch-up failed: %v", err))
+	}
+
+	return nil
+}
+
+// Stop cancels the context of the module and waits for a running Fetch, the partial
+// aggregation windows and the observations which are not yet posted. Observations and
+// locations send after Stop returned are dropped until the module is started again
+func (c *ConnectorModuleBase) Stop() {
+	c.mutex.Lock()
+	s := c.scheduler
+	c.scheduler = nil
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.backfill != nil {
+		c.backfill.cancel()
+	}
+	c.stopRefresh()
+	c.ModuleData.Status.NextRun = ""
+	c.mutex.Unlock()
+
+	timeout := c.ModuleData.StopTimeout
+	if timeout <= 0 {
+		timeout = defaultStopTimeout
+	}
+	deadline := time.Now().Add(time.Second * time.Duration(timeout))
+
+	if s != nil {
+		select {
+		case <-s.done:
+		case <-time.After(time.Until(deadline)):
+			c.ModuleData.AddError(fmt.Errorf("fetch did not finish within %v seconds after stop", timeout))
+		}
+	}
+
+	c.Flush()
+
+	for atomic.LoadInt64(&c.pending) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	if pending := atomic.LoadInt64(&c.pending); pending > 0 {
+		c.ModuleData.AddError(fmt.Errorf("%v messages were not posted within %v seconds after stop", pending, timeout))
+	}
+
+	atomic.StoreInt32(&c.stopped, 1)
+}
+
+// Context returns the context of the running module, the context is cancelled when the module is stopped
+func (c *ConnectorModuleBase) Context() context.Context {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// isStopped returns true when the module is stopped and should not send messages
+func (c *ConnectorModuleBase) isStopped() bool {
+	return atomic.LoadInt32(&c.stopped) == 1
+}
+
+// trackPost wraps the status callback of a message so pending posts can be awaited on Stop,
+// the post is no longer pending after the first call so Stop does not wait for held messages
+func (c *ConnectorModuleBase) trackPost(status PostStatus) PostStatus {
+	atomic.AddInt64(&c.pending, 1)
+	var done int32
+	return func(resp *http.Response, err error) {
+		if atomic.CompareAndSwapInt32(&done, 0, 1) {
+			atomic.AddInt64(&c.pending, -1)
+		}
+
+		status(resp, err)
+	}
+}
@@ -0,0 +1,159 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingFetcher signals every Fetch on fetched and blocks until release is closed,
+// it returns early when the context is done unless ignoreContext is set
+type blockingFetcher struct {
+	fetched       chan context.Context
+	release       chan struct{}
+	ignoreContext bool
+}
+
+func (f *blockingFetcher) Fetch(ctx context.Context) {
+	f.fetched <- ctx
+	if f.ignoreContext {
+		<-f.release
+		return
+	}
+
+	select {
+	case <-f.release:
+	case <-ctx.Done():
+	}
+}
+
+func newLifecycleTestBase(ignoreContext bool) (*ConnectorModuleBase, *blockingFetcher) {
+	c, _ := newAggregationTestBase()
+	f := &blockingFetcher{fetched: make(chan context.Context, 10), release: make(chan struct{}), ignoreContext: ignoreContext}
+	c.Fetcher = f
+	c.Schedule = Schedule{IntervalSeconds: 3600}
+	c.ModuleData.StopTimeout = 1
+	return c, f
+}
+
+func TestStartStop(t *testing.T) {
+	c, f := newLifecycleTestBase(false)
+	defer close(f.release)
+
+	for run := 0; run < 2; run++ {
+		if err := c.Start(false); err != nil {
+			t.Fatalf("run %v: unable to start: %v", run, err)
+		}
+
+		var ctx context.Context
+		select {
+		case ctx = <-f.fetched:
+		case <-time.After(time.Second):
+			t.Fatalf("run %v: no fetch on start", run)
+		}
+
+		if c.isStopped() {
+			t.Errorf("run %v: module stopped after start", run)
+		}
+
+		c.Stop()
+		if ctx.Err() == nil {
+			t.Errorf("run %v: context of the fetch not cancelled on stop", run)
+		}
+		if !c.isStopped() {
+			t.Errorf("run %v: module not stopped", run)
+		}
+		if len(c.ModuleData.Status.LastErrors) != 0 {
+			t.Errorf("run %v: unexpected errors %v", run, c.ModuleData.Status.LastErrors)
+		}
+	}
+}
+
+func TestStartWithoutFetcher(t *testing.T) {
+	c, _ := newAggregationTestBase()
+	if err := c.Start(false); err == nil {
+		t.Errorf("expected an error starting a module without Fetcher")
+	}
+}
+
+func TestStartInvalidSchedule(t *testing.T) {
+	c, _ := newLifecycleTestBase(false)
+	c.Schedule = Schedule{Cron: "invalid"}
+	if err := c.Start(false); err == nil {
+		t.Errorf("expected an error starting a module with an invalid cron expression")
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	c, f := newLifecycleTestBase(true)
+	defer close(f.release)
+
+	if err := c.Start(false); err != nil {
+		t.Fatalf("unable to start: %v", err)
+	}
+	<-f.fetched
+
+	start := time.Now()
+	c.Stop()
+	if d := time.Since(start); d < time.Millisecond*900 || d > time.Second*3 {
+		t.Errorf("expected stop to wait for the stop timeout, waited %v", d)
+	}
+
+	errs := strings.Join(c.ModuleData.Status.LastErrors, ",")
+	if !strings.Contains(errs, "did not finish") {
+		t.Errorf("expected an error for the running fetch, got %v", errs)
+	}
+}
+
+func TestStopPendingPosts(t *testing.T) {
+	c, f := newLifecycleTestBase(false)
+	defer close(f.release)
+
+	if err := c.Start(false); err != nil {
+		t.Fatalf("unable to start: %v", err)
+	}
+	<-f.fetched
+
+	status := c.trackPost(func(resp *http.Response, err error) {})
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		status(nil, nil)
+	}()
+
+	start := time.Now()
+	c.Stop()
+	if d := time.Since(start); d < time.Millisecond*200 {
+		t.Errorf("expected stop to wait for the pending post, waited %v", d)
+	}
+	if len(c.ModuleData.Status.LastErrors) != 0 {
+		t.Errorf("unexpected errors %v", c.ModuleData.Status.LastErrors)
+	}
+}
+
+func TestStopHeldPosts(t *testing.T) {
+	c, f := newLifecycleTestBase(false)
+	defer close(f.release)
+
+	if err := c.Start(false); err != nil {
+		t.Fatalf("unable to start: %v", err)
+	}
+	<-f.fetched
+
+	// a held message is no longer pending, its result is counted when it is send
+	status := c.trackPost(c.statusCallback)
+	status(nil, HeldError{Err: fmt.Errorf("circuit breaker is open")})
+
+	start := time.Now()
+	c.Stop()
+	if d := time.Since(start); d > time.Millisecond*500 {
+		t.Errorf("expected stop not to wait for the held message, waited %v", d)
+	}
+
+	status(nil, nil)
+	if c.pending != 0 || c.ModuleData.Status.ObservationsPostedOk != 1 {
+		t.Errorf("expected no pending posts and 1 posted observation, got %v and %v", c.pending, c.ModuleData.Status.ObservationsPostedOk)
+	}
+}
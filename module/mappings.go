@@ -0,0 +1,310 @@
+package module
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// DatastreamInfo describes a Datastream a module sends observations to
+type DatastreamInfo struct {
+	Host         string `json:"host"`
+	StreamID     string `json:"streamId"`
+	DatastreamID string `json:"datastreamId"`
+	ThingName    string `json:"thingName,omitempty"`
+}
+
+// ThingInfo describes a Thing a module sends locations to
+type ThingInfo struct {
+	Host    string `json:"host"`
+	ThingID string `json:"thingId"`
+}
+
+// settingsMapping is the part of a mapping which is the same for all modules
+type settingsMapping struct {
+	Server            string             `json:"server"`
+	ThingID           string             `json:"thingId"`
+	Thing             *ThingDefinition   `json:"thing"`
+	FeatureOfInterest *FeatureOfInterest `json:"featureOfInterest"`
+	Streams           []settingsStream   `json:"streams"`
+}
+
+// settingsStream is the part of a stream which is the same for all modules
+type settingsStream struct {
+	StreamID      string                `json:"streamId"`
+	Datastream    *DatastreamDefinition `json:"datastream"`
+	DatastreamRef *DatastreamReference  `json:"datastreamRef"`
+	Destinations  []settingsDestination `json:"destinations"`
+	Transform     *Transform            `json:"transform"`
+	Suppression   *SuppressionPolicy    `json:"suppression"`
+	Aggregation   *Aggregation          `json:"aggregation"`
+	Quality       *QualityRules         `json:"quality"`
+	Feature       *FeatureOfInterest    `json:"featureOfInterest"`
+}
+
+// settingsDestination is an additional server and Datastream a stream is send to,
+// the server of the mapping is used when no server is set
+type settingsDestination struct {
+	Server        string                `json:"server"`
+	StreamID      string                `json:"streamId"`
+	Datastream    *DatastreamDefinition `json:"datastream"`
+	DatastreamRef *DatastreamReference  `json:"datastreamRef"`
+}
+
+// destination is a server and streamId an observation is send to, the streamId
+// can be the key of a Datastream reference
+type destination struct {
+	host     string
+	streamID string
+}
+
+// parseMappings reads the mappings from the module settings and collects the Datastreams and
+// Things the module sends data to. Streams which have a Datastream definition or reference instead
+// of a streamId get a reference key as streamId so the module passes it to SendObservation where
+// it is replaced by the id of the provisioned or resolved Datastream. Streams with destinations
+// get a fan-out key as streamId which is send to all destinations of the stream
+func (c *ConnectorModuleBase) parseMappings(source []byte) ([]byte, error) {
+	raw := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(source))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	rawMappings, ok := raw["mappings"].([]interface{})
+	if !ok {
+		return source, nil
+	}
+
+	settingsMappings, err := redactMappings(rawMappings)
+	if err != nil {
+		return nil, err
+	}
+
+	c.settingsMappings = settingsMappings
+
+	changed := false
+	for i, rm := range rawMappings {
+		rawMapping, ok := rm.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		mapping := settingsMapping{}
+		if err := remarshal(rawMapping, &mapping); err != nil {
+			return nil, fmt.Errorf("mapping %v: %v", i, err)
+		}
+
+		host := c.ModuleData.ResolveServer(mapping.Server)
+		if len(mapping.ThingID) > 0 {
+			c.ModuleData.Things = append(c.ModuleData.Things, ThingInfo{Host: host, ThingID: mapping.ThingID})
+		}
+
+		mapped := c.newMapping(rawMapping, mapping.Server)
+		rawStreams, _ := rawMapping["streams"].([]interface{})
+		for j, stream := range mapping.Streams {
+			dests := make([]destination, 0)
+			d, err := c.addDestination(mapping, stream)
+			if err != nil {
+				return nil, fmt.Errorf("mapping %v stream %v: %v", i, j, err)
+			}
+
+			if len(d.streamID) > 0 {
+				dests = append(dests, d)
+			}
+
+			for k, sd := range stream.Destinations {
+				m := mapping
+				if len(sd.Server) > 0 {
+					m.Server = sd.Server
+				}
+
+				d, err := c.addDestination(m, settingsStream{StreamID: sd.StreamID, Datastream: sd.Datastream, DatastreamRef: sd.DatastreamRef})
+				if err != nil {
+					return nil, fmt.Errorf("mapping %v stream %v destination %v: %v", i, j, k, err)
+				}
+
+				if len(d.streamID) == 0 {
+					return nil, fmt.Errorf("mapping %v stream %v destination %v: no streamId, datastream or datastreamRef set", i, j, k)
+				}
+
+				dests = append(dests, d)
+			}
+
+			key := d.streamID
+			if len(stream.Destinations) > 0 {
+				key = fmt.Sprintf("fanout:%v/%v", i, j)
+				c.fanouts[key] = dests
+			}
+
+			if stream.Transform != nil {
+				if err := stream.Transform.validate(); err != nil {
+					return nil, fmt.Errorf("mapping %v stream %v transform: %v", i, j, err)
+				}
+
+				c.transforms[streamKey(host, key)] = stream.Transform
+			}
+
+			if stream.Suppression != nil {
+				if err := stream.Suppression.validate(); err != nil {
+					return nil, fmt.Errorf("mapping %v stream %v suppression: %v", i, j, err)
+				}
+
+				c.suppressions[streamKey(host, key)] = stream.Suppression
+			}
+
+			if stream.Aggregation != nil {
+				if err := stream.Aggregation.validate(); err != nil {
+					return nil, fmt.Errorf("mapping %v stream %v aggregation: %v", i, j, err)
+				}
+
+				c.aggregations[streamKey(host, key)] = stream.Aggregation
+			}
+
+			feature := mapping.FeatureOfInterest
+			if stream.Feature != nil {
+				feature = stream.Feature
+			}
+
+			if feature != nil {
+				if len(feature.Name) == 0 || len(feature.Feature) == 0 {
+					return nil, fmt.Errorf("mapping %v stream %v: a featureOfInterest needs a name and a feature", i, j)
+				}
+
+				c.features[streamKey(host, key)] = feature
+			}
+
+			if stream.Quality != nil {
+				if err := stream.Quality.validate(); err != nil {
+					return nil, fmt.Errorf("mapping %v stream %v quality: %v", i, j, err)
+				}
+
+				c.qualityRules[streamKey(host, key)] = stream.Quality
+			}
+
+			if j < len(rawStreams) {
+				c.addRoute(mapped, rawStreams[j], key)
+			}
+
+			if key != stream.StreamID {
+				rawStreams[j].(map[string]interface{})["streamId"] = key
+				changed = true
+			}
+		}
+
+		c.mappings = append(c.mappings, *mapped)
+	}
+
+	if !changed {
+		return source, nil
+	}
+
+	return json.Marshal(raw)
+}
+
+// addDestination returns the destination for a stream and registers its Datastream, the
+// returned streamId is empty when the stream has no streamId, definition or reference
+func (c *ConnectorModuleBase) addDestination(mapping settingsMapping, stream settingsStream) (destination, error) {
+	host := c.ModuleData.ResolveServer(mapping.Server)
+	d := destination{host: host, streamID: stream.StreamID}
+	if len(d.streamID) == 0 {
+		key, err := c.addStreamRef(mapping, stream, host)
+		if err != nil {
+			return d, err
+		}
+
+		if len(key) == 0 {
+			return d, nil
+		}
+
+		d.streamID = key
+	}
+
+	thingName := ""
+	if stream.Datastream != nil && stream.Datastream.Thing != nil {
+		thingName = stream.Datastream.Thing.Name
+	} else if mapping.Thing != nil {
+		thingName = mapping.Thing.Name
+	}
+
+	c.ModuleData.Datastreams = append(c.ModuleData.Datastreams, DatastreamInfo{
+		Host:      host,
+		StreamID:  d.streamID,
+		ThingName: thingName,
+	})
+
+	return d, nil
+}
+
+// redactMappings returns a copy of the raw mappings with the user info removed from the
+// server urls of the mappings and destinations
+func redactMappings(rawMappings []interface{}) (json.RawMessage, error) {
+	b, err := json.Marshal(rawMappings)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := make([]interface{}, 0)
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&mappings); err != nil {
+		return nil, err
+	}
+
+	for _, rm := range mappings {
+		rawMapping, ok := rm.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		redactServer(rawMapping)
+		rawStreams, _ := rawMapping["streams"].([]interface{})
+		for _, rs := range rawStreams {
+			rawStream, _ := rs.(map[string]interface{})
+			rawDestinations, _ := rawStream["destinations"].([]interface{})
+			for _, rd := range rawDestinations {
+				if rawDestination, ok := rd.(map[string]interface{}); ok {
+					redactServer(rawDestination)
+				}
+			}
+		}
+	}
+
+	return json.Marshal(mappings)
+}
+
+// redactServer removes the user info from the server url of a raw mapping or destination
+func redactServer(raw map[string]interface{}) {
+	if server, ok := raw["server"].(string); ok {
+		raw["server"] = RedactURL(server)
+	}
+}
+
+// streamKey returns the key for a stream of a mapping
+func streamKey(host, streamID string) string {
+	return fmt.Sprintf("%s|%s", host, streamID)
+}
+
+// updateDatastreamIDs sets the resolved Datastream id for all Datastreams of the module,
+// it is called whenever the id of a reference changes
+func (c *ConnectorModuleBase) updateDatastreamIDs() {
+	data := c.ModuleData
+	if data.datastreamsMutex != nil {
+		data.datastreamsMutex.Lock()
+		defer data.datastreamsMutex.Unlock()
+	}
+
+	for i, ds := range data.Datastreams {
+		data.Datastreams[i].DatastreamID = c.cachedDatastreamID(ds.StreamID)
+	}
+}
+
+// remarshal converts a generic JSON value into the given target
+func remarshal(source interface{}, target interface{}) error {
+	b, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, target)
+}
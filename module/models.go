@@ -1,7 +1,10 @@
 package module
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"sync"
 )
 
 // IConnectorModule defines the functions that need to be implemented by a module
@@ -23,17 +26,83 @@ type IConnectorModule interface {
 // PostStatus function definition is used as a callback when posting data to a SensorThings server
 type PostStatus func(response *http.Response, err error)
 
-// ConnectorModuleStatus contains information about the status of a module
+// OutboxError is passed to a PostStatus callback when a message could not be posted yet but is
+// kept in the outbox of the connector, the outbox counts the message once it is retried
+type OutboxError struct {
+	Err error
+}
+
+func (e OutboxError) Error() string {
+	return fmt.Sprintf("%v, message kept in outbox", e.Err)
+}
+
+// IsOutboxError returns true when the message was handed to the outbox instead of being posted
+func IsOutboxError(err error) bool {
+	_, ok := err.(OutboxError)
+	return ok
+}
+
+// HeldError is passed to a PostStatus callback when a message could not be posted yet because the
+// server is unreachable and is held in memory by the connector, the callback is called again with
+// the result when the message is send
+type HeldError struct {
+	Err error
+}
+
+func (e HeldError) Error() string {
+	return fmt.Sprintf("%v, message held until the server is reachable", e.Err)
+}
+
+// IsHeldError returns true when the message is held by the connector instead of being posted
+func IsHeldError(err error) bool {
+	_, ok := err.(HeldError)
+	return ok
+}
+
+// ConnectorModuleStatus contains information about the status of a module, State is
+// changed by the connector and the module at the same time and should only be accessed
+// using GetState, SetState and UpdateState of ConnectorModuleData
 type ConnectorModuleStatus struct {
-	MaxErrors                int      `json:"-"`
-	Fatal                    bool     `json:"fatal"`
-	Running                  bool     `json:"running"`
-	LastGet                  string   `json:"lastGet"`
-	LastPost                 string   `json:"lastPost"`
-	ObservationsPostedOk     int64    `json:"postSuccess"`
-	ObservationsPostedFailed int64    `json:"postFailed"`
-	ErrorCount               int      `json:"errorCount"`
-	LastErrors               []string `json:"lastErrors"`
+	stateMutex               *sync.Mutex
+	MaxErrors                int                  `json:"-"`
+	State                    string               `json:"state"`
+	LastGet                  string               `json:"lastGet"`
+	LastPost                 string               `json:"lastPost"`
+	LastRun                  string               `json:"lastRun"`
+	NextRun                  string               `json:"nextRun"`
+	ObservationsPostedOk     int64                `json:"postSuccess"`
+	ObservationsPostedFailed int64                `json:"postFailed"`
+	OutboxPending            int64                `json:"outboxPending"`
+	ErrorCount               int                  `json:"errorCount"`
+	LastErrors               []string             `json:"lastErrors"`
+	WarningCount             int                  `json:"warningCount"`
+	LastWarnings             []string             `json:"lastWarnings"`
+	ValidationErrors         []string             `json:"validationErrors"`
+	Destinations             []*DestinationStatus `json:"destinations"`
+	Backfill                 *BackfillStatus      `json:"backfill"`
+}
+
+// MarshalJSON copies the status while the state is locked
+func (s *ConnectorModuleStatus) MarshalJSON() ([]byte, error) {
+	type status ConnectorModuleStatus
+	if s.stateMutex == nil {
+		return json.Marshal((*status)(s))
+	}
+
+	s.stateMutex.Lock()
+	c := status(*s)
+	s.stateMutex.Unlock()
+
+	return json.Marshal(&c)
+}
+
+// DestinationStatus contains the post counters for a single server and Datastream
+type DestinationStatus struct {
+	Host                     string `json:"host"`
+	DatastreamID             string `json:"datastreamId"`
+	LastPost                 string `json:"lastPost"`
+	ObservationsPostedOk     int64  `json:"postSuccess"`
+	ObservationsPostedFailed int64  `json:"postFailed"`
 }
 
 // ErrorMessage send over ErrorChannel, an ErrorMessage should be send from a module
@@ -41,6 +110,7 @@ type ConnectorModuleStatus struct {
 type ErrorMessage struct {
 	ModuleID string
 	Fatal    bool
+	Warning  bool
 	Error    error
 }
 
@@ -65,6 +135,11 @@ type LocationMessage struct {
 }
 
 type dummySettings struct {
-	ModuleID                   string `json:"moduleId"`
-	AllowDuplicateResultValues *bool  `json:"allowDuplicateResultValues"`
+	ModuleID                   string             `json:"moduleId"`
+	AllowDuplicateResultValues *bool              `json:"allowDuplicateResultValues"`
+	DatastreamRefreshSeconds   *int               `json:"datastreamRefreshSeconds"`
+	Suppression                *SuppressionPolicy `json:"suppression"`
+	CheckPhenomenonTime        *bool              `json:"checkPhenomenonTime"`
+	FetchIntervalSeconds       *int               `json:"fetchIntervalSeconds"`
+	Schedule                   *Schedule          `json:"schedule"`
 }
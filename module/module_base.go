@@ -1,26 +1,63 @@
 package module
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const defaultDatastreamRefreshInterval = 3600
+
 // ConnectorModuleBase is the base implementation for a module this
 // can be used to easily create a new module
 type ConnectorModuleBase struct {
-	ID                       string
-	ModuleName               string
-	ModuleDescription        string
-	AllowDuplicateResults    bool
-	mutex                    *sync.Mutex
-	ModuleData               *ConnectorModuleData
-	Endpoints                []Endpoint
-	LatestObservationResults map[string]map[string]string
+	ID                        string
+	ModuleName                string
+	ModuleDescription         string
+	AllowDuplicateResults     bool
+	CheckPhenomenonTime       bool
+	Suppression               SuppressionPolicy
+	DatastreamRefreshInterval int
+	MinFetchInterval          int
+	Schedule                  Schedule
+	Fetcher                   IFetcher
+	Backfiller                IBackfiller
+	MappingKeys               MappingKeys
+	mutex                     *sync.Mutex
+	ModuleData                *ConnectorModuleData
+	Endpoints                 []Endpoint
+	LatestObservationResults  map[string]map[string]string
+	datastreamRefs            map[string]*datastreamRef
+	fanouts                   map[string][]destination
+	destinations              map[string]*DestinationStatus
+	transforms                map[string]*Transform
+	suppressions              map[string]*SuppressionPolicy
+	lastPosts                 map[string]*postState
+	saveTimer                 *time.Timer
+	aggregations              map[string]*Aggregation
+	windows                   map[string]*window
+	aggMutex                  *sync.Mutex
+	qualityRules              map[string]*QualityRules
+	qualityStates             map[string]*qualityState
+	features                  map[string]*FeatureOfInterest
+	mappings                  []deviceMapping
+	settingsMappings          json.RawMessage
+	readingIndex              map[string][]route
+	scheduler                 *scheduler
+	backfill                  *Backfill
+	catchUp                   *catchUp
+	ctx                       context.Context
+	cancel                    context.CancelFunc
+	stopped                   int32
+	pending                   int64
+	refMutex                  *sync.Mutex
+	refreshTicker             *time.Ticker
 }
 
 // GetID returns the module id
@@ -52,13 +89,34 @@ func (c *ConnectorModuleBase) GetConnectorModuleData() *ConnectorModuleData {
 func (c *ConnectorModuleBase) SetConnectorModuleData(data *ConnectorModuleData) {
 	c.ModuleData = data
 	c.AllowDuplicateResults = true
+	c.CheckPhenomenonTime = true
+	c.DatastreamRefreshInterval = defaultDatastreamRefreshInterval
 	c.mutex = &sync.Mutex{}
 	c.LatestObservationResults = make(map[string]map[string]string)
+	c.datastreamRefs = make(map[string]*datastreamRef)
+	c.fanouts = make(map[string][]destination)
+	c.destinations = make(map[string]*DestinationStatus)
+	c.transforms = make(map[string]*Transform)
+	c.suppressions = make(map[string]*SuppressionPolicy)
+	c.lastPosts = make(map[string]*postState)
+	c.aggregations = make(map[string]*Aggregation)
+	c.windows = make(map[string]*window)
+	c.aggMutex = &sync.Mutex{}
+	c.qualityRules = make(map[string]*QualityRules)
+	c.qualityStates = make(map[string]*qualityState)
+	c.features = make(map[string]*FeatureOfInterest)
+	c.mappings = make([]deviceMapping, 0)
+	c.readingIndex = make(map[string][]route)
+	c.refMutex = &sync.Mutex{}
+	c.loadState()
 }
 
-// GetEndpoints return the configured endpoints for the module
+// GetEndpoints return the configured endpoints for the module including the
+// endpoints of the base module
 func (c *ConnectorModuleBase) GetEndpoints() []Endpoint {
-	return c.Endpoints
+	eps := make([]Endpoint, 0)
+	eps = append(eps, c.Endpoints...)
+	return append(eps, c.stateEndpoint())
 }
 
 // SendError sends an error message over the ErrorChannel to the connector
@@ -73,25 +131,153 @@ func (c *ConnectorModuleBase) SendError(err error, fatal bool) {
 	ch <- msg
 }
 
-// SendObservation sends an error message over the ObservationChannel to the connector
+// SendWarning sends a warning over the ErrorChannel to the connector, warnings are
+// logged and kept in the module status but not counted as errors
+func (c *ConnectorModuleBase) SendWarning(err error) {
+	msg := ErrorMessage{
+		ModuleID: c.GetID(),
+		Warning:  true,
+		Error:    err,
+	}
+
+	ch := *c.ModuleData.ErrorChannel
+	ch <- msg
+}
+
+// SendObservation sends an observation message over the ObservationChannel to the connector,
+// host can be the url or the name of a server configured in the connector. When the datastreamID
+// is the streamId of a stream with destinations the observation is send to every destination,
+// the transform, quality rules and FeatureOfInterest configured for the stream are applied to the result before it
+// is send. Streams with an aggregation send a single observation at the end of every window
 func (c *ConnectorModuleBase) SendObservation(host, datastreamID string, observation Observation) {
+	if c.isStopped() {
+		return
+	}
+
+	c.sendStream(c.ModuleData.ResolveServer(host), datastreamID, observation, nil)
+}
+
+// sendStream applies the transform, FeatureOfInterest, quality rules and aggregation of a stream
+// to an observation before it is send to the destinations of the stream, b is set for observations
+// of a backfill which use their own quality state and aggregation windows
+func (c *ConnectorModuleBase) sendStream(host, datastreamID string, observation Observation, b *Backfill) {
+	key := streamKey(host, datastreamID)
+	if t, ok := c.transforms[key]; ok {
+		observation.Result = t.Apply(observation.Result)
+	}
+
+	if f, ok := c.features[key]; ok && observation.FeatureOfInterest == nil {
+		observation.FeatureOfInterest = f
+	}
+
+	if !c.checkQuality(host, datastreamID, &observation, b) {
+		return
+	}
+
+	if a, ok := c.aggregations[key]; ok {
+		o, err := c.aggregate(host, datastreamID, a, observation, b)
+		if err != nil {
+			c.SendWarning(err)
+		}
+
+		if o == nil {
+			return
+		}
+
+		observation = *o
+	}
+
+	c.fanOut(host, datastreamID, observation, b)
+}
+
+// fanOut sends an observation for a stream to all its destinations, b is set for
+// observations of a backfill
+func (c *ConnectorModuleBase) fanOut(host, datastreamID string, observation Observation, b *Backfill) {
+	policy := &c.Suppression
+	if p, ok := c.suppressions[streamKey(host, datastreamID)]; ok {
+		policy = p
+	}
+
+	for _, d := range c.streamDestinations(host, datastreamID) {
+		c.sendObservation(d.host, d.streamID, observation, policy, b)
+	}
+}
+
+// streamDestinations returns the servers and Datastreams an observation for a stream is send to
+func (c *ConnectorModuleBase) streamDestinations(host, datastreamID string) []destination {
+	if destinations, ok := c.fanouts[datastreamID]; ok {
+		return destinations
+	}
+
+	return []destination{{host: host, streamID: datastreamID}}
+}
+
+// sendObservation sends an observation to a single server and Datastream, duplicate
+// results and the suppression policy are checked per server and Datastream. Observations of a
+// backfill which are older than the last posted observation are checked against the previous
+// observation of the backfill instead
+func (c *ConnectorModuleBase) sendObservation(host, datastreamID string, observation Observation, policy *SuppressionPolicy, b *Backfill) {
+	datastreamID, err := c.resolveDatastreamID(datastreamID)
+	if err == errResolveBackoff {
+		return
+	} else if err != nil {
+		c.SendError(err, false)
+		return
+	}
+
 	c.mutex.Lock()
-	c.ModuleData.Status.LastGet = time.Now().UTC().String()
+	now := time.Now().UTC()
+	c.ModuleData.Status.LastGet = now.String()
 
 	if _, ok := c.LatestObservationResults[host]; !ok {
 		c.LatestObservationResults[host] = make(map[string]string)
 	}
 
-	if latestResult, ok := c.LatestObservationResults[host][datastreamID]; ok {
-		if !c.AllowDuplicateResults && latestResult == fmt.Sprintf("%v", observation.Result) {
-			c.mutex.Unlock()
-			return
+	key := streamKey(host, datastreamID)
+	phenomenonTime, hasTime := parsePhenomenonTime(observation.PhenomenonTime)
+	lastPosts, live := c.lastPosts, true
+	if last, ok := c.lastPosts[key]; ok && b != nil && hasTime && phenomenonTime.Before(last.phenomenonTime) {
+		lastPosts, live = b.lastPosts, false
+	}
+
+	if after, ok := b.afterTime(key); ok && hasTime && !phenomenonTime.After(after) {
+		c.mutex.Unlock()
+		return
+	}
+
+	if last, ok := lastPosts[key]; ok && c.CheckPhenomenonTime && hasTime && !last.phenomenonTime.IsZero() && !phenomenonTime.After(last.phenomenonTime) {
+		c.mutex.Unlock()
+		if phenomenonTime.Before(last.phenomenonTime) {
+			c.SendWarning(fmt.Errorf("skipped observation for Datastream(%s) on %s, phenomenonTime %s is older than the last posted %s", datastreamID, RedactURL(host), observation.PhenomenonTime, last.phenomenonTime.Format(time.RFC3339Nano)))
 		}
+		return
+	}
+
+	// the intervals of the suppression policy are measured in phenomenonTime for a backfill
+	at := now
+	if b != nil && hasTime {
+		at = phenomenonTime
+	}
+
+	if policy.suppress(lastPosts[key], observation.Result, c.AllowDuplicateResults, at) {
+		c.mutex.Unlock()
+		return
 	}
 
 	// set latest result
-	c.LatestObservationResults[host][datastreamID] = fmt.Sprintf("%v", observation.Result)
-	c.ModuleData.Status.LastPost = time.Now().UTC().String()
+	lastPosts[key] = newPostState(host, datastreamID, observation.Result, phenomenonTime, at)
+	if b != nil {
+		b.sent()
+	}
+
+	if live {
+		c.scheduleSave()
+		c.LatestObservationResults[host][datastreamID] = fmt.Sprintf("%v", observation.Result)
+	}
+
+	c.ModuleData.Status.LastPost = now.String()
+	status := c.destinationStatus(host, datastreamID)
+	status.LastPost = c.ModuleData.Status.LastPost
 	c.mutex.Unlock()
 
 	msg := ObservationMessage{
@@ -99,33 +285,75 @@ func (c *ConnectorModuleBase) SendObservation(host, datastreamID string, observa
 		DatastreamID: datastreamID,
 		ModuleID:     c.GetID(),
 		Observation:  observation,
-		Status:       c.statusCallback,
+		Status:       c.trackPost(c.destinationCallback(status)),
 	}
 
 	ch := *c.ModuleData.ObservationChannel
 	ch <- msg
 }
 
-// SendLocation sends a location message over the LocationChannel to the connector
+// destinationStatus returns the status for a server and Datastream, c.mutex should be locked
+func (c *ConnectorModuleBase) destinationStatus(host, datastreamID string) *DestinationStatus {
+	key := streamKey(host, datastreamID)
+	if status, ok := c.destinations[key]; ok {
+		return status
+	}
+
+	status := &DestinationStatus{Host: RedactURL(host), DatastreamID: datastreamID}
+	c.destinations[key] = status
+	c.ModuleData.Status.Destinations = append(c.ModuleData.Status.Destinations, status)
+	return status
+}
+
+// destinationCallback updates the counters of a destination before calling the statusCallback
+func (c *ConnectorModuleBase) destinationCallback(status *DestinationStatus) PostStatus {
+	return func(resp *http.Response, err error) {
+		if IsOutboxError(err) || IsHeldError(err) {
+			return
+		}
+
+		if err == nil {
+			atomic.AddInt64(&status.ObservationsPostedOk, 1)
+		} else {
+			atomic.AddInt64(&status.ObservationsPostedFailed, 1)
+		}
+
+		c.statusCallback(resp, err)
+	}
+}
+
+// SendLocation sends a location message over the LocationChannel to the connector,
+// host can be the url or the name of a server configured in the connector
 func (c *ConnectorModuleBase) SendLocation(host, thingID string, location Location) {
+	if c.isStopped() {
+		return
+	}
+
+	host = c.ModuleData.ResolveServer(host)
 	msg := LocationMessage{
 		Host:     host,
 		ThingID:  thingID,
 		ModuleID: c.GetID(),
 		Location: location,
-		Status:   c.statusCallback,
+		Status:   c.trackPost(c.statusCallback),
 	}
 
 	ch := *c.ModuleData.LocationChannel
 	ch <- msg
 }
 
+// statusCallback counts the result of a post, messages kept in the outbox are
+// counted by the connector when they are retried and held messages when they are send
 func (c *ConnectorModuleBase) statusCallback(resp *http.Response, err error) {
+	if IsOutboxError(err) || IsHeldError(err) {
+		return
+	}
+
 	c.mutex.Lock()
 	if err == nil {
-		c.ModuleData.Status.ObservationsPostedOk = c.ModuleData.Status.ObservationsPostedOk + 1
+		atomic.AddInt64(&c.ModuleData.Status.ObservationsPostedOk, 1)
 	} else {
-		c.ModuleData.Status.ObservationsPostedFailed = c.ModuleData.Status.ObservationsPostedFailed + 1
+		atomic.AddInt64(&c.ModuleData.Status.ObservationsPostedFailed, 1)
 
 		if resp != nil && resp.Body != nil {
 			defer resp.Body.Close()
@@ -150,6 +378,11 @@ func (c *ConnectorModuleBase) GetSettings(settings interface{}) error {
 		return fmt.Errorf("%s %v", errorStringBase, err)
 	}
 
+	source, err = c.parseMappings(source)
+	if err != nil {
+		return fmt.Errorf("%s %v", errorStringBase, err)
+	}
+
 	err = json.Unmarshal(source, settings)
 	if err != nil {
 		return fmt.Errorf("%s %v", errorStringBase, err)
@@ -166,7 +399,37 @@ func (c *ConnectorModuleBase) GetSettings(settings interface{}) error {
 		if dummy.AllowDuplicateResultValues != nil {
 			c.AllowDuplicateResults = *dummy.AllowDuplicateResultValues
 		}
+
+		if dummy.CheckPhenomenonTime != nil {
+			c.CheckPhenomenonTime = *dummy.CheckPhenomenonTime
+		}
+
+		if dummy.Suppression != nil {
+			if err := dummy.Suppression.validate(); err != nil {
+				return fmt.Errorf("%s %v", errorStringBase, err)
+			}
+
+			c.Suppression = *dummy.Suppression
+		}
+
+		if dummy.FetchIntervalSeconds != nil {
+			c.Schedule.IntervalSeconds = *dummy.FetchIntervalSeconds
+		}
+
+		if dummy.Schedule != nil {
+			if err := dummy.Schedule.validate(); err != nil {
+				return fmt.Errorf("%s %v", errorStringBase, err)
+			}
+
+			c.Schedule = *dummy.Schedule
+		}
+
+		if dummy.DatastreamRefreshSeconds != nil {
+			c.DatastreamRefreshInterval = *dummy.DatastreamRefreshSeconds
+		}
 	}
 
+	c.resolveDatastreamRefs()
+	c.updateDatastreamIDs()
 	return nil
 }
@@ -0,0 +1,48 @@
+package module
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPhenomenonTimeOrder(t *testing.T) {
+	tests := []struct {
+		name           string
+		check          bool
+		phenomenonTime string
+		sent           bool
+		warning        bool
+	}{
+		{"first observation", true, "2020-01-01T00:01:00Z", true, false},
+		{"same phenomenonTime", true, "2020-01-01T00:01:00Z", false, false},
+		{"same instant in another zone", true, "2020-01-01T01:01:00+01:00", false, false},
+		{"older phenomenonTime", true, "2020-01-01T00:00:00Z", false, true},
+		{"newer phenomenonTime", true, "2020-01-01T00:02:00Z", true, false},
+		{"without phenomenonTime", true, "", true, false},
+		{"older without check", false, "2020-01-01T00:00:00Z", true, false},
+	}
+
+	c, observations := newAggregationTestBase()
+	errors := *c.ModuleData.ErrorChannel
+	for i, test := range tests {
+		c.CheckPhenomenonTime = test.check
+		c.SendObservation("http://gost/v1.0/", "1", Observation{Result: i, PhenomenonTime: test.phenomenonTime})
+
+		if sent := len(observations) == 1; sent != test.sent {
+			t.Errorf("%s: expected sent %v, got %v", test.name, test.sent, sent)
+		}
+		for len(observations) > 0 {
+			<-observations
+		}
+
+		if warning := len(errors) == 1; warning != test.warning {
+			t.Errorf("%s: expected warning %v, got %v", test.name, test.warning, warning)
+		}
+		for len(errors) > 0 {
+			msg := <-errors
+			if !msg.Warning || !strings.Contains(msg.Error.Error(), "is older than the last posted") {
+				t.Errorf("%s: unexpected error %v", test.name, msg.Error)
+			}
+		}
+	}
+}
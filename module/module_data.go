@@ -1,6 +1,9 @@
 package module
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
 // NewConnectorModuleData creates a new ConnectorModuleData object
 func NewConnectorModuleData(version, fileName, filePath string, obsChannel *chan ObservationMessage, locChannel *chan LocationMessage, errorChannel *chan ErrorMessage) *ConnectorModuleData {
@@ -11,10 +14,17 @@ func NewConnectorModuleData(version, fileName, filePath string, obsChannel *chan
 		ObservationChannel: obsChannel,
 		LocationChannel:    locChannel,
 		ErrorChannel:       errorChannel,
-		Status:             &ConnectorModuleStatus{},
+		Status:             &ConnectorModuleStatus{stateMutex: &sync.Mutex{}},
+		Datastreams:        make([]DatastreamInfo, 0),
+		Things:             make([]ThingInfo, 0),
+		datastreamsMutex:   &sync.Mutex{},
 	}
 
+	cm.SetState(StateStopped)
 	cm.Status.LastErrors = make([]string, 0)
+	cm.Status.LastWarnings = make([]string, 0)
+	cm.Status.ValidationErrors = make([]string, 0)
+	cm.Status.Destinations = make([]*DestinationStatus, 0)
 
 	return &cm
 }
@@ -29,6 +39,60 @@ type ConnectorModuleData struct {
 	ObservationChannel *chan ObservationMessage `json:"-"`
 	LocationChannel    *chan LocationMessage    `json:"-"`
 	ErrorChannel       *chan ErrorMessage       `json:"-"`
+	DataPath           string                   `json:"-"`
+	StopTimeout        int                      `json:"-"`
+	Servers            map[string]string        `json:"-"`
+	SensorThings       ISensorThingsClient      `json:"-"`
+	Datastreams        []DatastreamInfo         `json:"datastreams"`
+	Things             []ThingInfo              `json:"things"`
+	datastreamsMutex   *sync.Mutex
+}
+
+// ResolveServer returns the url of a server configured in the connector by name,
+// when no server with the given name exists the input is returned as url
+func (c *ConnectorModuleData) ResolveServer(nameOrURL string) string {
+	if u, ok := c.Servers[nameOrURL]; ok {
+		return u
+	}
+
+	return nameOrURL
+}
+
+// GetDatastreams returns a copy of the Datastreams of the module, the ids of Datastream
+// references are updated while the module is running
+func (c *ConnectorModuleData) GetDatastreams() []DatastreamInfo {
+	if c.datastreamsMutex != nil {
+		c.datastreamsMutex.Lock()
+		defer c.datastreamsMutex.Unlock()
+	}
+
+	return append([]DatastreamInfo{}, c.Datastreams...)
+}
+
+// GetState returns the state of the module
+func (c *ConnectorModuleData) GetState() string {
+	c.Status.stateMutex.Lock()
+	defer c.Status.stateMutex.Unlock()
+
+	return c.Status.State
+}
+
+// SetState sets the state of the module
+func (c *ConnectorModuleData) SetState(state string) {
+	c.Status.stateMutex.Lock()
+	defer c.Status.stateMutex.Unlock()
+
+	c.Status.State = state
+}
+
+// UpdateState sets the state of the module to the state returned by update for the current
+// state, the state can not change in between. Returns the new state
+func (c *ConnectorModuleData) UpdateState(update func(state string) string) string {
+	c.Status.stateMutex.Lock()
+	defer c.Status.stateMutex.Unlock()
+
+	c.Status.State = update(c.Status.State)
+	return c.Status.State
 }
 
 // AddError adds a new error to the list of errors for the module
@@ -48,3 +112,17 @@ func (c *ConnectorModuleData) AddError(err error) {
 		c.Status.LastErrors = append(c.Status.LastErrors[:maxErrors], c.Status.LastErrors[maxErrors+1:]...)
 	}
 }
+
+// AddWarning adds a new warning to the list of warnings for the module
+func (c *ConnectorModuleData) AddWarning(err error) {
+	maxWarnings := c.Status.MaxErrors
+	if maxWarnings == 0 {
+		maxWarnings = 50
+	}
+
+	c.Status.WarningCount = c.Status.WarningCount + 1
+	c.Status.LastWarnings = append([]string{fmt.Sprintf("%v", err)}, c.Status.LastWarnings...)
+	if len(c.Status.LastWarnings) > maxWarnings {
+		c.Status.LastWarnings = c.Status.LastWarnings[:maxWarnings]
+	}
+}
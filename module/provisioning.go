@@ -0,0 +1,323 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	resolveBackoffMin = time.Second * 10
+	resolveBackoffMax = time.Minute * 10
+)
+
+// errResolveBackoff is returned for a reference which failed to resolve recently, the
+// observation is dropped without reporting the failure again
+var errResolveBackoff = errors.New("waiting to resolve again")
+
+// ISensorThingsClient is implemented by the connector and gives modules access to
+// the entities on a SensorThings server
+type ISensorThingsClient interface {
+	ProvisionDatastream(host string, thing ThingDefinition, datastream DatastreamDefinition) (string, error)
+	ResolveDatastream(host string, reference DatastreamReference) (string, error)
+	LatestPhenomenonTime(host, datastreamID string) (string, error)
+}
+
+// DatastreamReference references an existing Datastream by the name of its Thing and
+// the name of the Datastream, or by an OData filter which should match one Datastream
+type DatastreamReference struct {
+	Thing  string `json:"thing,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Filter string `json:"filter,omitempty"`
+}
+
+// ThingDefinition describes a Thing which is looked up by name on a server
+// and created when it does not exist
+type ThingDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Properties  map[string]interface{} `json:"properties,omitempty"`
+	Location    *Location              `json:"location,omitempty"`
+}
+
+// SensorDefinition describes a Sensor which is looked up by name on a server
+// and created when it does not exist
+type SensorDefinition struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	EncodingType string `json:"encodingType"`
+	Metadata     string `json:"metadata"`
+}
+
+// ObservedPropertyDefinition describes an ObservedProperty which is looked up by
+// name on a server and created when it does not exist
+type ObservedPropertyDefinition struct {
+	Name        string `json:"name"`
+	Definition  string `json:"definition"`
+	Description string `json:"description"`
+}
+
+// UnitOfMeasurement of a Datastream
+type UnitOfMeasurement struct {
+	Name       string `json:"name"`
+	Symbol     string `json:"symbol"`
+	Definition string `json:"definition"`
+}
+
+// DatastreamDefinition describes a Datastream which is looked up by name for its Thing
+// and created together with its Sensor and ObservedProperty when it does not exist
+type DatastreamDefinition struct {
+	Name              string                     `json:"name"`
+	Description       string                     `json:"description"`
+	ObservationType   string                     `json:"observationType"`
+	UnitOfMeasurement UnitOfMeasurement          `json:"unitOfMeasurement"`
+	Sensor            SensorDefinition           `json:"sensor"`
+	ObservedProperty  ObservedPropertyDefinition `json:"observedProperty"`
+	Thing             *ThingDefinition           `json:"thing,omitempty"`
+}
+
+// datastreamRef is a Datastream in the settings which is not configured by id but
+// provisioned or resolved on the server, resolving is locked while the id is looked up.
+// After a failed lookup the next one waits until retryAt, the backoff doubles on every failure
+type datastreamRef struct {
+	host       string
+	thing      ThingDefinition
+	datastream *DatastreamDefinition
+	reference  *DatastreamReference
+	id         string
+	resolving  *sync.Mutex
+	retryAt    time.Time
+	backoff    time.Duration
+}
+
+// addStreamRef registers a stream which has a Datastream definition or reference instead of a
+// streamId, the returned key is used as streamId for the stream. Returns an empty key when
+// the stream has no definition or reference
+func (c *ConnectorModuleBase) addStreamRef(mapping settingsMapping, stream settingsStream, host string) (string, error) {
+	if stream.DatastreamRef != nil {
+		return c.addReference(mapping.Server, host, *stream.DatastreamRef)
+	}
+
+	if stream.Datastream == nil {
+		return "", nil
+	}
+
+	thing := mapping.Thing
+	if stream.Datastream.Thing != nil {
+		thing = stream.Datastream.Thing
+	}
+
+	if thing == nil || len(thing.Name) == 0 || len(stream.Datastream.Name) == 0 {
+		return "", fmt.Errorf("a datastream needs a name and a thing with a name")
+	}
+
+	key := fmt.Sprintf("provision:%s/%s/%s", mapping.Server, thing.Name, stream.Datastream.Name)
+	c.datastreamRefs[key] = &datastreamRef{
+		host:       host,
+		thing:      *thing,
+		datastream: stream.Datastream,
+		resolving:  &sync.Mutex{},
+	}
+
+	return key, nil
+}
+
+// addReference registers a reference to an existing Datastream and returns its key
+func (c *ConnectorModuleBase) addReference(server, host string, reference DatastreamReference) (string, error) {
+	var key string
+	if len(reference.Filter) > 0 {
+		key = fmt.Sprintf("resolve:%s/%s", server, reference.Filter)
+	} else if len(reference.Thing) > 0 && len(reference.Name) > 0 {
+		key = fmt.Sprintf("resolve:%s/%s/%s", server, reference.Thing, reference.Name)
+	} else {
+		return "", fmt.Errorf("a datastreamRef needs a filter or a thing and name")
+	}
+
+	c.datastreamRefs[key] = &datastreamRef{
+		host:      host,
+		reference: &reference,
+		resolving: &sync.Mutex{},
+	}
+
+	return key, nil
+}
+
+// resolveDatastreamRefs tries to resolve all Datastream references, references which
+// cannot be resolved now will be retried when an observation is send for them. The module
+// is not yet registered at the connector during setup so errors are added to the module directly
+func (c *ConnectorModuleBase) resolveDatastreamRefs() {
+	for key := range c.datastreamRefs {
+		if _, err := c.resolveDatastreamID(key); err != nil && err != errResolveBackoff {
+			c.ModuleData.AddError(err)
+		}
+	}
+}
+
+// startRefresh refreshes the references to existing Datastreams on an interval while the
+// module is running so changes on the server are picked up, c.mutex should be locked
+func (c *ConnectorModuleBase) startRefresh(ctx context.Context) {
+	hasReferences := false
+	for _, ref := range c.datastreamRefs {
+		hasReferences = hasReferences || ref.reference != nil
+	}
+
+	if !hasReferences || c.refreshTicker != nil || c.DatastreamRefreshInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second * time.Duration(c.DatastreamRefreshInterval))
+	c.refreshTicker = ticker
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.refreshDatastreamRefs()
+			}
+		}
+	}()
+}
+
+// stopRefresh stops refreshing the references, c.mutex should be locked
+func (c *ConnectorModuleBase) stopRefresh() {
+	if c.refreshTicker != nil {
+		c.refreshTicker.Stop()
+		c.refreshTicker = nil
+	}
+}
+
+// refreshDatastreamRefs resolves all references to existing Datastreams again, the
+// previous id is kept when a reference can not be resolved
+func (c *ConnectorModuleBase) refreshDatastreamRefs() {
+	for key, ref := range c.datastreamRefs {
+		if ref.reference == nil {
+			continue
+		}
+
+		id, err := c.ModuleData.SensorThings.ResolveDatastream(ref.host, *ref.reference)
+		if err != nil {
+			c.SendError(fmt.Errorf("unable to refresh %s on %s: %v", key, RedactURL(ref.host), err), false)
+			continue
+		}
+
+		c.setDatastreamID(ref, id)
+	}
+}
+
+// setDatastreamID stores the id of a resolved reference and updates the Datastreams of the module
+func (c *ConnectorModuleBase) setDatastreamID(ref *datastreamRef, id string) {
+	c.refMutex.Lock()
+	changed := ref.id != id
+	ref.id = id
+	ref.retryAt = time.Time{}
+	ref.backoff = 0
+	c.refMutex.Unlock()
+
+	if changed {
+		c.updateDatastreamIDs()
+	}
+}
+
+// resolveFailed delays the next lookup of a reference which could not be resolved
+func (c *ConnectorModuleBase) resolveFailed(ref *datastreamRef) {
+	c.refMutex.Lock()
+	defer c.refMutex.Unlock()
+
+	ref.backoff *= 2
+	if ref.backoff < resolveBackoffMin {
+		ref.backoff = resolveBackoffMin
+	} else if ref.backoff > resolveBackoffMax {
+		ref.backoff = resolveBackoffMax
+	}
+
+	ref.retryAt = time.Now().Add(ref.backoff)
+}
+
+// waitingToResolve returns true when the lookup of a reference failed and the backoff did not pass
+func (c *ConnectorModuleBase) waitingToResolve(ref *datastreamRef) bool {
+	c.refMutex.Lock()
+	defer c.refMutex.Unlock()
+
+	return time.Now().Before(ref.retryAt)
+}
+
+// streamIdentifiers returns the streamId of the settings and the ids of the Datastreams a
+// stream is send to, streamID can be a reference or fan-out key
+func (c *ConnectorModuleBase) streamIdentifiers(streamID string) []string {
+	ids := []string{streamID}
+	if dests, ok := c.fanouts[streamID]; ok {
+		for _, d := range dests {
+			ids = append(ids, c.streamIdentifiers(d.streamID)...)
+		}
+
+		return ids
+	}
+
+	if id := c.cachedDatastreamID(streamID); len(id) > 0 && id != streamID {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// cachedDatastreamID returns the Datastream id for a streamId used in the settings without
+// contacting the server, an empty string is returned for unresolved references
+func (c *ConnectorModuleBase) cachedDatastreamID(streamID string) string {
+	ref, ok := c.datastreamRefs[streamID]
+	if !ok {
+		return streamID
+	}
+
+	c.refMutex.Lock()
+	defer c.refMutex.Unlock()
+	return ref.id
+}
+
+// resolveDatastreamID returns the Datastream id for a streamId used in the settings, ids
+// for Datastream references are looked up or created on the server and cached. Only one
+// lookup per reference runs at a time, other references and cached ids are not blocked.
+// A reference which failed to resolve is not looked up again until its backoff passed
+func (c *ConnectorModuleBase) resolveDatastreamID(streamID string) (string, error) {
+	ref, ok := c.datastreamRefs[streamID]
+	if !ok {
+		return streamID, nil
+	}
+
+	if id := c.cachedDatastreamID(streamID); len(id) > 0 {
+		return id, nil
+	}
+
+	ref.resolving.Lock()
+	defer ref.resolving.Unlock()
+
+	if id := c.cachedDatastreamID(streamID); len(id) > 0 {
+		return id, nil
+	}
+
+	if c.ModuleData.SensorThings == nil {
+		return "", fmt.Errorf("unable to resolve %s: connector does not support resolving Datastreams", streamID)
+	}
+
+	if c.waitingToResolve(ref) {
+		return "", errResolveBackoff
+	}
+
+	var id string
+	var err error
+	if ref.reference != nil {
+		id, err = c.ModuleData.SensorThings.ResolveDatastream(ref.host, *ref.reference)
+	} else {
+		id, err = c.ModuleData.SensorThings.ProvisionDatastream(ref.host, ref.thing, *ref.datastream)
+	}
+
+	if err != nil {
+		c.resolveFailed(ref)
+		return "", fmt.Errorf("unable to resolve %s on %s: %v", streamID, RedactURL(ref.host), err)
+	}
+
+	c.setDatastreamID(ref, id)
+	return id, nil
+}
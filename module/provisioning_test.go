@@ -0,0 +1,183 @@
+package module
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testReference  = "resolve:http://gost/v1.0//thing/temperature"
+	testDefinition = "provision:http://gost/v1.0//thing/humidity"
+)
+
+// resolveClient resolves and provisions Datastreams by name with the ids in ids,
+// names which are not in ids fail. Every lookup is counted in calls
+type resolveClient struct {
+	mutex *sync.Mutex
+	ids   map[string]string
+	calls int
+}
+
+func (r *resolveClient) lookup(name string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.calls++
+	id, ok := r.ids[name]
+	if !ok {
+		return "", fmt.Errorf("unavailable")
+	}
+
+	return id, nil
+}
+
+func (r *resolveClient) set(name, id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.ids[name] = id
+}
+
+func (r *resolveClient) ProvisionDatastream(host string, thing ThingDefinition, datastream DatastreamDefinition) (string, error) {
+	return r.lookup(datastream.Name)
+}
+
+func (r *resolveClient) ResolveDatastream(host string, reference DatastreamReference) (string, error) {
+	return r.lookup(reference.Name)
+}
+
+func (r *resolveClient) LatestPhenomenonTime(host, datastreamID string) (string, error) {
+	return "", fmt.Errorf("not supported")
+}
+
+func newProvisioningTestBase(t *testing.T, ids map[string]string) (*ConnectorModuleBase, *resolveClient) {
+	c := newReadingsTestBase(MappingKeys{})
+	client := &resolveClient{mutex: &sync.Mutex{}, ids: ids}
+	c.ModuleData.SensorThings = client
+
+	source := `{"mappings":[{"deviceKey":"d1","server":"http://gost/v1.0/","streams":[` +
+		`{"fieldKey":"t","datastreamRef":{"thing":"thing","name":"temperature"}},` +
+		`{"fieldKey":"h","datastream":{"name":"humidity","thing":{"name":"thing"}}},` +
+		`{"fieldKey":"p","streamId":"7"}]}]}`
+	if _, err := c.parseMappings([]byte(source)); err != nil {
+		t.Fatalf("unable to parse mappings: %v", err)
+	}
+
+	c.updateDatastreamIDs()
+	return c, client
+}
+
+// datastreamIDs returns the Datastream ids of the module by streamId
+func datastreamIDs(c *ConnectorModuleBase) map[string]string {
+	ids := make(map[string]string)
+	for _, ds := range c.ModuleData.GetDatastreams() {
+		ids[ds.StreamID] = ds.DatastreamID
+	}
+
+	return ids
+}
+
+func TestResolveDatastreamID(t *testing.T) {
+	tests := []struct {
+		name     string
+		ids      map[string]string
+		streamID string
+		expected string
+		err      bool
+	}{
+		{"streamId", map[string]string{}, "7", "7", false},
+		{"reference", map[string]string{"temperature": "42"}, testReference, "42", false},
+		{"definition", map[string]string{"humidity": "43"}, testDefinition, "43", false},
+		{"unresolved reference", map[string]string{}, testReference, "", true},
+	}
+
+	for _, test := range tests {
+		c, _ := newProvisioningTestBase(t, test.ids)
+		id, err := c.resolveDatastreamID(test.streamID)
+		if (err != nil) != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+		if id != test.expected {
+			t.Errorf("%s: expected id %q, got %q", test.name, test.expected, id)
+		}
+
+		// the Datastreams of the module show the resolved id
+		if ids := datastreamIDs(c); ids[test.streamID] != test.expected {
+			t.Errorf("%s: expected Datastream id %q, got %q", test.name, test.expected, ids[test.streamID])
+		}
+	}
+}
+
+func TestResolveBackoff(t *testing.T) {
+	c, client := newProvisioningTestBase(t, map[string]string{})
+	ref := c.datastreamRefs[testReference]
+
+	if _, err := c.resolveDatastreamID(testReference); err == nil || err == errResolveBackoff {
+		t.Fatalf("expected the lookup to fail, got %v", err)
+	}
+
+	// observations for the reference are dropped without lookup or error during the backoff
+	c.sendObservation("http://gost/v1.0/", testReference, Observation{Result: 1}, nil, nil)
+	if _, err := c.resolveDatastreamID(testReference); err != errResolveBackoff {
+		t.Errorf("expected to wait for the backoff, got %v", err)
+	}
+	if client.calls != 1 || len(*c.ModuleData.ErrorChannel) != 0 {
+		t.Errorf("expected a single lookup and no errors, got %v lookups and %v errors", client.calls, len(*c.ModuleData.ErrorChannel))
+	}
+
+	// the backoff doubles on every failed lookup
+	ref.retryAt = time.Now()
+	c.resolveDatastreamID(testReference)
+	if client.calls != 2 || ref.backoff != resolveBackoffMin*2 {
+		t.Errorf("expected a second lookup with a backoff of %v, got %v lookups with %v", resolveBackoffMin*2, client.calls, ref.backoff)
+	}
+
+	// a successful lookup resets the backoff
+	client.set("temperature", "42")
+	ref.retryAt = time.Now()
+	if id, err := c.resolveDatastreamID(testReference); err != nil || id != "42" {
+		t.Errorf("expected Datastream 42, got %v %v", id, err)
+	}
+	if ref.backoff != 0 || datastreamIDs(c)[testReference] != "42" {
+		t.Errorf("expected a reset backoff and updated Datastreams, got %v %v", ref.backoff, datastreamIDs(c))
+	}
+}
+
+func TestRefreshDatastreamRefs(t *testing.T) {
+	c, client := newProvisioningTestBase(t, map[string]string{"temperature": "42", "humidity": "43"})
+	c.resolveDatastreamRefs()
+
+	tests := []struct {
+		name      string
+		available bool
+		id        string
+		errors    int
+	}{
+		{"changed Datastream", true, "44", 0},
+		{"unavailable server", false, "44", 1},
+	}
+
+	for _, test := range tests {
+		if test.available {
+			client.set("temperature", test.id)
+		} else {
+			client.ids = map[string]string{}
+		}
+
+		calls := client.calls
+		c.refreshDatastreamRefs()
+
+		ids := datastreamIDs(c)
+		if ids[testReference] != test.id || ids[testDefinition] != "43" {
+			t.Errorf("%s: expected Datastream %s for the reference and 43 for the definition, got %v", test.name, test.id, ids)
+		}
+		if client.calls != calls+1 {
+			t.Errorf("%s: expected only the reference to be refreshed, got %v lookups", test.name, client.calls-calls)
+		}
+		if errors := len(*c.ModuleData.ErrorChannel); errors != test.errors {
+			t.Errorf("%s: expected %v errors, got %v", test.name, test.errors, errors)
+		}
+	}
+}
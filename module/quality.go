@@ -0,0 +1,150 @@
+package module
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Actions for observations which fail a quality rule
+const (
+	QualityActionMark  = "mark"
+	QualityActionDrop  = "drop"
+	QualityActionRoute = "route"
+)
+
+// Result qualities set by the quality rules
+const (
+	QualityGood         = "good"
+	QualityMissing      = "missing"
+	QualityOutOfRange   = "out-of-range"
+	QualityRateOfChange = "rate-of-change"
+	QualityStuck        = "stuck"
+)
+
+// QualityRules are checked for every result of a stream, the resultQuality of an observation is set to
+// good or to the first rule which failed. Failed observations are send with their resultQuality (mark),
+// dropped (drop) or send to the suspect Datastream of the stream (route)
+type QualityRules struct {
+	Min              *float64 `json:"min,omitempty"`
+	Max              *float64 `json:"max,omitempty"`
+	MaxRatePerMinute *float64 `json:"maxRatePerMinute,omitempty"`
+	StuckSamples     int      `json:"stuckSamples,omitempty"`
+	CheckMissing     bool     `json:"checkMissing,omitempty"`
+	Action           string   `json:"action,omitempty"`
+	SuspectStreamID  string   `json:"suspectStreamId,omitempty"`
+}
+
+// qualityState holds the previous results of a stream used by the rate of change and stuck value rules
+type qualityState struct {
+	last    float64
+	time    time.Time
+	hasLast bool
+	equal   int
+}
+
+// validate checks if the action of the rules is known
+func (q *QualityRules) validate() error {
+	switch q.Action {
+	case "", QualityActionMark, QualityActionDrop:
+	case QualityActionRoute:
+		if len(q.SuspectStreamID) == 0 {
+			return fmt.Errorf("no suspectStreamId set for action %s", QualityActionRoute)
+		}
+	default:
+		return fmt.Errorf("unknown quality action %s", q.Action)
+	}
+
+	if q.StuckSamples < 0 {
+		return fmt.Errorf("stuckSamples can not be negative")
+	}
+
+	return nil
+}
+
+// check returns the quality of a result, state is updated with the result
+func (q *QualityRules) check(state *qualityState, observation Observation) string {
+	v, number := toFloat(observation.Result)
+	if observation.Result == nil || (number && (math.IsNaN(v) || math.IsInf(v, 0))) {
+		if q.CheckMissing {
+			return QualityMissing
+		}
+
+		return QualityGood
+	}
+
+	if !number {
+		return QualityGood
+	}
+
+	t, ok := parsePhenomenonTime(observation.PhenomenonTime)
+	if !ok {
+		t = time.Now().UTC()
+	}
+
+	if state.hasLast && v == state.last {
+		state.equal++
+	} else {
+		state.equal = 1
+	}
+
+	if (q.Min != nil && v < *q.Min) || (q.Max != nil && v > *q.Max) {
+		return QualityOutOfRange
+	}
+
+	if q.StuckSamples > 0 && state.equal >= q.StuckSamples {
+		state.last, state.time, state.hasLast = v, t, true
+		return QualityStuck
+	}
+
+	if q.MaxRatePerMinute != nil && state.hasLast {
+		if minutes := t.Sub(state.time).Minutes(); minutes > 0 && math.Abs(v-state.last)/minutes > *q.MaxRatePerMinute {
+			state.last, state.time, state.hasLast = v, t, true
+			return QualityRateOfChange
+		}
+	}
+
+	state.last, state.time, state.hasLast = v, t, true
+	return QualityGood
+}
+
+// checkQuality applies the quality rules of a stream to an observation, returns false when
+// the observation should not be send to the Datastreams of the stream. A backfill b keeps
+// its own quality state so the previous results are the previous results of the backfill
+func (c *ConnectorModuleBase) checkQuality(host, streamID string, observation *Observation, b *Backfill) bool {
+	key := streamKey(host, streamID)
+	rules, ok := c.qualityRules[key]
+	if !ok {
+		return true
+	}
+
+	c.mutex.Lock()
+	states := c.qualityStates
+	if b != nil {
+		states = b.qualityStates
+	}
+
+	state, ok := states[key]
+	if !ok {
+		state = &qualityState{}
+		states[key] = state
+	}
+
+	quality := rules.check(state, *observation)
+	c.mutex.Unlock()
+
+	observation.ResultQuality = quality
+	if quality == QualityGood {
+		return true
+	}
+
+	switch rules.Action {
+	case QualityActionDrop:
+		return false
+	case QualityActionRoute:
+		c.sendObservation(host, rules.SuspectStreamID, *observation, &SuppressionPolicy{}, b)
+		return false
+	}
+
+	return true
+}
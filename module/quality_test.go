@@ -0,0 +1,69 @@
+package module
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQualityRulesCheck(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	type sample struct {
+		time   string
+		result interface{}
+	}
+
+	tests := []struct {
+		name      string
+		rules     QualityRules
+		samples   []sample
+		qualities []string
+	}{
+		{"no rules", QualityRules{}, []sample{{"2020-01-01T00:00:00Z", 1.0}, {"2020-01-01T00:01:00Z", nil}},
+			[]string{QualityGood, QualityGood}},
+		{"missing", QualityRules{CheckMissing: true}, []sample{{"2020-01-01T00:00:00Z", nil}, {"2020-01-01T00:01:00Z", math.NaN()}, {"2020-01-01T00:02:00Z", math.Inf(1)}, {"2020-01-01T00:03:00Z", 1.0}},
+			[]string{QualityMissing, QualityMissing, QualityMissing, QualityGood}},
+		{"not a number", QualityRules{Min: f(0), CheckMissing: true}, []sample{{"2020-01-01T00:00:00Z", "open"}},
+			[]string{QualityGood}},
+		{"out of range", QualityRules{Min: f(-10), Max: f(40)}, []sample{{"2020-01-01T00:00:00Z", -11.0}, {"2020-01-01T00:01:00Z", 41.0}, {"2020-01-01T00:02:00Z", 40.0}, {"2020-01-01T00:03:00Z", "-20"}},
+			[]string{QualityOutOfRange, QualityOutOfRange, QualityGood, QualityOutOfRange}},
+		{"rate of change", QualityRules{MaxRatePerMinute: f(2)}, []sample{{"2020-01-01T00:00:00Z", 10.0}, {"2020-01-01T00:01:00Z", 12.0}, {"2020-01-01T00:02:00Z", 20.0}, {"2020-01-01T00:06:00Z", 20.0}},
+			[]string{QualityGood, QualityGood, QualityRateOfChange, QualityGood}},
+		{"rate of change compared to last result", QualityRules{MaxRatePerMinute: f(1)}, []sample{{"2020-01-01T00:00:00Z", 10.0}, {"2020-01-01T00:01:00Z", 50.0}, {"2020-01-01T00:02:00Z", 50.5}, {"2020-01-01T00:03:00Z", 10.0}},
+			[]string{QualityGood, QualityRateOfChange, QualityGood, QualityRateOfChange}},
+		{"stuck", QualityRules{StuckSamples: 3}, []sample{{"2020-01-01T00:00:00Z", 5.0}, {"2020-01-01T00:01:00Z", 5.0}, {"2020-01-01T00:02:00Z", 5.0}, {"2020-01-01T00:03:00Z", 5.0}, {"2020-01-01T00:04:00Z", 6.0}},
+			[]string{QualityGood, QualityGood, QualityStuck, QualityStuck, QualityGood}},
+		{"out of range before stuck", QualityRules{Max: f(1), StuckSamples: 2}, []sample{{"2020-01-01T00:00:00Z", 5.0}, {"2020-01-01T00:01:00Z", 5.0}},
+			[]string{QualityOutOfRange, QualityOutOfRange}},
+	}
+
+	for _, test := range tests {
+		state := &qualityState{}
+		for i, s := range test.samples {
+			if q := test.rules.check(state, Observation{PhenomenonTime: s.time, Result: s.result}); q != test.qualities[i] {
+				t.Errorf("%s: sample %v expected %s, got %s", test.name, i, test.qualities[i], q)
+			}
+		}
+	}
+}
+
+func TestQualityRulesValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules QualityRules
+		valid bool
+	}{
+		{"default action", QualityRules{}, true},
+		{"mark", QualityRules{Action: QualityActionMark}, true},
+		{"drop", QualityRules{Action: QualityActionDrop}, true},
+		{"route", QualityRules{Action: QualityActionRoute, SuspectStreamID: "2"}, true},
+		{"route without suspect stream", QualityRules{Action: QualityActionRoute}, false},
+		{"unknown action", QualityRules{Action: "ignore"}, false},
+		{"negative stuck samples", QualityRules{StuckSamples: -1}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.rules.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Reading is a single value read from a vendor API for a field of a device, for example
+// the Temperature of a Netatmo module
+type Reading struct {
+	DeviceKey string
+	FieldKey  string
+	Value     interface{}
+	Time      time.Time
+}
+
+// MappingKeys names the properties of the mappings and streams in the module config which
+// contain the device and field keys of a reading, deviceKey and fieldKey are used when not set
+type MappingKeys struct {
+	Device string
+	Field  string
+}
+
+// deviceMapping is the module independent form of a mapping from the module config
+type deviceMapping struct {
+	deviceKey string
+	server    string
+	streams   []deviceStream
+}
+
+// deviceStream is the module independent form of a stream from the module config
+type deviceStream struct {
+	fieldKey string
+	streamID string
+}
+
+// route is a server and streamId a reading is send to
+type route struct {
+	server   string
+	streamID string
+}
+
+// readingKey returns the key of a device and field in the reading index
+func readingKey(deviceKey, fieldKey string) string {
+	return fmt.Sprintf("%s|%s", deviceKey, fieldKey)
+}
+
+// mappingValue returns the value of a property from the module config as string, the
+// generic name is used when the property of the module is not set
+func mappingValue(raw map[string]interface{}, name, generic string) string {
+	v, ok := raw[name]
+	if !ok {
+		v, ok = raw[generic]
+	}
+
+	if !ok || v == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+// newMapping creates the module independent form of a raw mapping
+func (c *ConnectorModuleBase) newMapping(raw map[string]interface{}, server string) *deviceMapping {
+	return &deviceMapping{
+		deviceKey: mappingValue(raw, c.MappingKeys.Device, "deviceKey"),
+		server:    server,
+		streams:   make([]deviceStream, 0),
+	}
+}
+
+// addRoute adds a stream to a mapping and to the reading index, streamID is the
+// possibly replaced streamId of the stream
+func (c *ConnectorModuleBase) addRoute(mapping *deviceMapping, raw interface{}, streamID string) {
+	rawStream, _ := raw.(map[string]interface{})
+	field := mappingValue(rawStream, c.MappingKeys.Field, "fieldKey")
+	if len(mapping.deviceKey) == 0 || len(field) == 0 || len(streamID) == 0 {
+		return
+	}
+
+	mapping.streams = append(mapping.streams, deviceStream{fieldKey: field, streamID: streamID})
+	key := readingKey(mapping.deviceKey, field)
+	c.readingIndex[key] = append(c.readingIndex[key], route{server: mapping.server, streamID: streamID})
+}
+
+// MappedDevices returns the device keys used in the mappings of the module
+func (c *ConnectorModuleBase) MappedDevices() []string {
+	devices := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, m := range c.mappings {
+		if len(m.deviceKey) > 0 && !seen[m.deviceKey] {
+			seen[m.deviceKey] = true
+			devices = append(devices, m.deviceKey)
+		}
+	}
+
+	return devices
+}
+
+// GetMappings returns the mappings as written in the module config with redacted server urls,
+// modules use it to show their mappings without the streamIds generated by the connector
+func (c *ConnectorModuleBase) GetMappings() json.RawMessage {
+	if c.settingsMappings == nil {
+		return json.RawMessage("[]")
+	}
+
+	return c.settingsMappings
+}
+
+// SendReadings sends every reading to the streams its device and field are mapped to,
+// readings which are not mapped and duplicate readings are ignored
+func (c *ConnectorModuleBase) SendReadings(readings []Reading) {
+	c.routeReadings(readings, c.SendObservation)
+}
+
+// SendReadings sends backfilled readings to the streams their device and field are mapped to
+func (b *Backfill) SendReadings(readings []Reading) {
+	b.module.routeReadings(readings, b.SendObservation)
+}
+
+func (c *ConnectorModuleBase) routeReadings(readings []Reading, send func(host, streamID string, observation Observation)) {
+	seen := make(map[string]bool)
+	for _, r := range readings {
+		key := readingKey(r.DeviceKey, r.FieldKey)
+		routes, ok := c.readingIndex[key]
+		if !ok {
+			continue
+		}
+
+		phenomenonTime := ""
+		if !r.Time.IsZero() {
+			phenomenonTime = r.Time.Format(time.RFC3339Nano)
+		}
+
+		id := fmt.Sprintf("%s|%s", key, phenomenonTime)
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+
+		for _, rt := range routes {
+			send(rt.server, rt.streamID, Observation{Result: r.Value, PhenomenonTime: phenomenonTime})
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const defaultFetchInterval = 300
+
+// IFetcher is implemented by modules which let the base module schedule their requests,
+// Fetch is called on every run of the schedule and should return when ctx is done
+type IFetcher interface {
+	Fetch(ctx context.Context)
+}
+
+// Schedule describes when a module fetches new readings, either every IntervalSeconds or
+// on the times matching a cron expression. The first run is delayed by a random jitter of
+// at most JitterSeconds which is also applied to all following runs to spread vendor load
+type Schedule struct {
+	IntervalSeconds int    `json:"intervalSeconds,omitempty"`
+	Cron            string `json:"cron,omitempty"`
+	JitterSeconds   int    `json:"jitterSeconds,omitempty"`
+	RunOnStart      *bool  `json:"runOnStart,omitempty"`
+}
+
+// scheduler runs the Fetch function of a module
+type scheduler struct {
+	schedule cron.Schedule
+	interval time.Duration
+	offset   time.Duration
+	done     chan struct{}
+}
+
+// validate checks if the cron expression of the schedule can be parsed
+func (s *Schedule) validate() error {
+	if len(s.Cron) == 0 {
+		return nil
+	}
+
+	if _, err := cron.ParseStandard(s.Cron); err != nil {
+		return fmt.Errorf("invalid cron expression %s: %v", s.Cron, err)
+	}
+
+	return nil
+}
+
+// newScheduler creates the scheduler for the schedule of the module
+func (c *ConnectorModuleBase) newScheduler() (*scheduler, error) {
+	s := &scheduler{
+		interval: time.Second * time.Duration(c.Schedule.IntervalSeconds),
+		done:     make(chan struct{}),
+	}
+
+	if len(c.Schedule.Cron) > 0 {
+		parsed, err := cron.ParseStandard(c.Schedule.Cron)
+		if err != nil {
+			return nil, err
+		}
+
+		s.schedule = parsed
+	}
+
+	if s.interval <= 0 {
+		s.interval = time.Second * time.Duration(defaultFetchInterval)
+	}
+
+	if min := time.Second * time.Duration(c.MinFetchInterval); s.interval < min {
+		s.interval = min
+	}
+
+	if c.Schedule.JitterSeconds > 0 {
+		s.offset = time.Duration(rand.Int63n(int64(time.Second * time.Duration(c.Schedule.JitterSeconds))))
+	}
+
+	return s, nil
+}
+
+// runSchedule calls Fetch on every run until ctx is done, runs are skipped when the
+// minimum fetch interval did not pass since the previous run. The done channel of the
+// scheduler is closed when a running Fetch returned after ctx is done
+func (c *ConnectorModuleBase) runSchedule(ctx context.Context, s *scheduler, runOnStart bool) {
+	defer close(s.done)
+
+	next := time.Now().Add(s.offset)
+	if !runOnStart {
+		next = s.next(time.Now())
+	}
+
+	var last time.Time
+	for {
+		c.setRunTimes(last, next)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		now := time.Now()
+		if last.IsZero() || now.Sub(last) >= time.Second*time.Duration(c.MinFetchInterval) {
+			last = now
+			c.setRunTimes(last, time.Time{})
+			c.Fetcher.Fetch(ctx)
+		}
+
+		next = s.next(last)
+		if !next.After(time.Now()) {
+			next = s.next(time.Now())
+		}
+	}
+}
+
+// next returns the time of the run after t
+func (s *scheduler) next(t time.Time) time.Time {
+	if s.schedule != nil {
+		return s.schedule.Next(t.Add(-s.offset)).Add(s.offset)
+	}
+
+	return t.Add(s.interval)
+}
+
+func (c *ConnectorModuleBase) setRunTimes(last, next time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !last.IsZero() {
+		c.ModuleData.Status.LastRun = last.UTC().String()
+	}
+
+	c.ModuleData.Status.NextRun = ""
+	if !next.IsZero() {
+		c.ModuleData.Status.NextRun = next.UTC().String()
+	}
+}
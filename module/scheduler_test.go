@@ -0,0 +1,81 @@
+package module
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerNext(t *testing.T) {
+	at := func(hour, minute, second int) time.Time {
+		return time.Date(2020, 1, 1, hour, minute, second, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		offset   time.Duration
+		t        time.Time
+		next     time.Time
+	}{
+		{"interval", Schedule{IntervalSeconds: 60}, 0, at(10, 3, 0), at(10, 4, 0)},
+		{"interval ignores offset", Schedule{IntervalSeconds: 60}, time.Second * 20, at(10, 3, 0), at(10, 4, 0)},
+		{"cron", Schedule{Cron: "*/10 * * * *"}, 0, at(10, 3, 0), at(10, 10, 0)},
+		{"cron on a run", Schedule{Cron: "*/10 * * * *"}, 0, at(10, 10, 0), at(10, 20, 0)},
+		{"cron with offset", Schedule{Cron: "*/10 * * * *"}, time.Second * 30, at(10, 3, 0), at(10, 10, 30)},
+		{"cron with offset after jittered run", Schedule{Cron: "*/10 * * * *"}, time.Second * 30, at(10, 10, 30), at(10, 20, 30)},
+		{"cron with offset before jittered run", Schedule{Cron: "*/10 * * * *"}, time.Second * 30, at(10, 10, 10), at(10, 10, 30)},
+		{"cron hourly", Schedule{Cron: "15 * * * *"}, 0, at(10, 20, 0), at(11, 15, 0)},
+	}
+
+	for _, test := range tests {
+		c := &ConnectorModuleBase{Schedule: test.schedule}
+		s, err := c.newScheduler()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		s.offset = test.offset
+		if next := s.next(test.t); !next.Equal(test.next) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.next, next)
+		}
+	}
+}
+
+func TestNewScheduler(t *testing.T) {
+	tests := []struct {
+		name             string
+		schedule         Schedule
+		minFetchInterval int
+		interval         time.Duration
+		valid            bool
+	}{
+		{"default interval", Schedule{}, 0, time.Second * defaultFetchInterval, true},
+		{"interval", Schedule{IntervalSeconds: 60}, 0, time.Minute, true},
+		{"minimum fetch interval", Schedule{IntervalSeconds: 60}, 600, time.Minute * 10, true},
+		{"jitter", Schedule{IntervalSeconds: 60, JitterSeconds: 5}, 0, time.Minute, true},
+		{"cron", Schedule{Cron: "0 * * * *"}, 0, time.Second * defaultFetchInterval, true},
+		{"invalid cron", Schedule{Cron: "every minute"}, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		c := &ConnectorModuleBase{Schedule: test.schedule, MinFetchInterval: test.minFetchInterval}
+		if err := test.schedule.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+
+		s, err := c.newScheduler()
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		if s.interval != test.interval {
+			t.Errorf("%s: expected interval %v, got %v", test.name, test.interval, s.interval)
+		}
+		if s.offset < 0 || s.offset > time.Second*time.Duration(test.schedule.JitterSeconds) {
+			t.Errorf("%s: offset %v outside the jitter of %v seconds", test.name, s.offset, test.schedule.JitterSeconds)
+		}
+	}
+}
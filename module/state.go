@@ -0,0 +1,189 @@
+package module
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const stateSaveDelay = 5 * time.Second
+
+// LastPost is the last result send to a server and Datastream, the last posts of a module
+// are kept in the data path of the connector so they survive a restart
+type LastPost struct {
+	Host           string     `json:"host"`
+	DatastreamID   string     `json:"datastreamId"`
+	Result         string     `json:"result"`
+	Time           time.Time  `json:"time"`
+	PhenomenonTime *time.Time `json:"phenomenonTime,omitempty"`
+}
+
+// statePath returns the file the last posts of the module are stored in, an empty
+// string is returned when the connector has no data path
+func (c *ConnectorModuleBase) statePath() string {
+	if len(c.ModuleData.DataPath) == 0 {
+		return ""
+	}
+
+	return filepath.Join(c.ModuleData.DataPath, "state", strings.Replace(c.ModuleData.ModuleFileName, ".so", ".json", 1))
+}
+
+// loadState reads the last posts stored by a previous run of the module
+func (c *ConnectorModuleBase) loadState() {
+	path := c.statePath()
+	if len(path) == 0 {
+		return
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	posts := make([]LastPost, 0)
+	if err := json.Unmarshal(b, &posts); err != nil {
+		c.ModuleData.AddError(err)
+		return
+	}
+
+	for _, p := range posts {
+		state := newPostState(p.Host, p.DatastreamID, p.Result, time.Time{}, p.Time)
+		if p.PhenomenonTime != nil {
+			state.phenomenonTime = *p.PhenomenonTime
+		}
+
+		c.lastPosts[streamKey(p.Host, p.DatastreamID)] = state
+		if _, ok := c.LatestObservationResults[p.Host]; !ok {
+			c.LatestObservationResults[p.Host] = make(map[string]string)
+		}
+		c.LatestObservationResults[p.Host][p.DatastreamID] = p.Result
+	}
+}
+
+// scheduleSave saves the last posts after a short delay so a poll sending many observations
+// results in a single write, c.mutex should be locked
+func (c *ConnectorModuleBase) scheduleSave() {
+	if c.saveTimer != nil || len(c.statePath()) == 0 {
+		return
+	}
+
+	c.saveTimer = time.AfterFunc(stateSaveDelay, c.saveState)
+}
+
+// saveState writes the last posts to the data path
+func (c *ConnectorModuleBase) saveState() {
+	c.mutex.Lock()
+	c.saveTimer = nil
+	posts := c.getLastPosts()
+	c.mutex.Unlock()
+
+	path := c.statePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		c.SendError(err, false)
+		return
+	}
+
+	b, _ := json.Marshal(posts)
+	if err := ioutil.WriteFile(path+".tmp", b, 0644); err != nil {
+		c.SendError(err, false)
+		return
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		c.SendError(err, false)
+	}
+}
+
+// getLastPosts returns the last posts of the module, c.mutex should be locked
+func (c *ConnectorModuleBase) getLastPosts() []LastPost {
+	posts := make([]LastPost, 0)
+	for _, s := range c.lastPosts {
+		p := LastPost{
+			Host:         s.host,
+			DatastreamID: s.datastreamID,
+			Result:       s.result,
+			Time:         s.time,
+		}
+
+		if !s.phenomenonTime.IsZero() {
+			t := s.phenomenonTime
+			p.PhenomenonTime = &t
+		}
+
+		posts = append(posts, p)
+	}
+
+	return posts
+}
+
+// resetLastPosts removes the last posts for a server and Datastream, all last posts are
+// removed when host and datastreamID are empty. Returns the number of removed last posts
+func (c *ConnectorModuleBase) resetLastPosts(host, datastreamID string) int {
+	c.mutex.Lock()
+	removed := 0
+	for key, s := range c.lastPosts {
+		if (len(host) > 0 && s.host != host) || (len(datastreamID) > 0 && s.datastreamID != datastreamID) {
+			continue
+		}
+
+		delete(c.lastPosts, key)
+		delete(c.LatestObservationResults[s.host], s.datastreamID)
+		removed++
+	}
+	c.mutex.Unlock()
+
+	if len(c.statePath()) > 0 {
+		c.saveState()
+	}
+
+	return removed
+}
+
+// stateEndpoint returns the endpoint to inspect and reset the last posts of the module
+func (c *ConnectorModuleBase) stateEndpoint() Endpoint {
+	return Endpoint{
+		Name: "LastPosts",
+		Operations: []EndpointOperation{
+			EndpointOperation{
+				OperationType: HTTPOperationGet,
+				Path:          "/LastPosts",
+				Handler:       c.getLastPostsHandler,
+			},
+			EndpointOperation{
+				OperationType: HTTPOperationDelete,
+				Path:          "/LastPosts",
+				Handler:       c.deleteLastPostsHandler,
+			},
+		},
+	}
+}
+
+func (c *ConnectorModuleBase) getLastPostsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	c.mutex.Lock()
+	posts := c.getLastPosts()
+	c.mutex.Unlock()
+
+	for i := range posts {
+		posts[i].Host = RedactURL(posts[i].Host)
+	}
+
+	SendJSONResponse(w, http.StatusOK, posts)
+}
+
+// deleteLastPostsHandler resets the last posts, the host and datastreamId query
+// parameters can be used to only reset a single server or Datastream
+func (c *ConnectorModuleBase) deleteLastPostsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	host := r.URL.Query().Get("host")
+	if len(host) > 0 {
+		host = c.ModuleData.ResolveServer(host)
+	}
+
+	removed := c.resetLastPosts(host, r.URL.Query().Get("datastreamId"))
+	SendJSONResponse(w, http.StatusOK, map[string]int{"removed": removed})
+}
@@ -0,0 +1,100 @@
+package module
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// SuppressionPolicy decides which observations of a stream are send, a result is not send
+// when it changed less than Deadband or DeadbandPercent compared to the last send result or
+// when MinIntervalSeconds did not pass since the last post. A result is always send when
+// HeartbeatSeconds passed since the last post
+type SuppressionPolicy struct {
+	Deadband           float64 `json:"deadband"`
+	DeadbandPercent    float64 `json:"deadbandPercent"`
+	MinIntervalSeconds int     `json:"minIntervalSeconds"`
+	HeartbeatSeconds   int     `json:"heartbeatSeconds"`
+}
+
+// postState is the last result send to a server and Datastream
+type postState struct {
+	host           string
+	datastreamID   string
+	result         string
+	value          float64
+	number         bool
+	time           time.Time
+	phenomenonTime time.Time
+}
+
+// validate checks if the policy contains no negative values
+func (p *SuppressionPolicy) validate() error {
+	if p.Deadband < 0 || p.DeadbandPercent < 0 || p.MinIntervalSeconds < 0 || p.HeartbeatSeconds < 0 {
+		return fmt.Errorf("suppression values can not be negative")
+	}
+
+	return nil
+}
+
+// suppress returns true when the result should not be send given the last send result,
+// allowDuplicates is false when equal results should not be send
+func (p *SuppressionPolicy) suppress(last *postState, result interface{}, allowDuplicates bool, now time.Time) bool {
+	if last == nil {
+		return false
+	}
+
+	elapsed := now.Sub(last.time)
+	if p.HeartbeatSeconds > 0 && elapsed >= time.Second*time.Duration(p.HeartbeatSeconds) {
+		return false
+	}
+
+	if p.MinIntervalSeconds > 0 && elapsed < time.Second*time.Duration(p.MinIntervalSeconds) {
+		return true
+	}
+
+	if !allowDuplicates && last.result == fmt.Sprintf("%v", result) {
+		return true
+	}
+
+	v, ok := toFloat(result)
+	if !ok || !last.number {
+		return false
+	}
+
+	delta := math.Abs(v - last.value)
+	if p.Deadband > 0 && delta < p.Deadband {
+		return true
+	}
+
+	if p.DeadbandPercent > 0 && delta < math.Abs(last.value)*p.DeadbandPercent/100 {
+		return true
+	}
+
+	return false
+}
+
+// newPostState creates the state for a result which is send
+func newPostState(host, datastreamID string, result interface{}, phenomenonTime, now time.Time) *postState {
+	v, ok := toFloat(result)
+	return &postState{
+		host:           host,
+		datastreamID:   datastreamID,
+		result:         fmt.Sprintf("%v", result),
+		value:          v,
+		number:         ok,
+		time:           now,
+		phenomenonTime: phenomenonTime,
+	}
+}
+
+// parsePhenomenonTime returns the time of an observation, the end is used for a time interval
+func parsePhenomenonTime(phenomenonTime string) (time.Time, bool) {
+	if i := strings.LastIndex(phenomenonTime, "/"); i >= 0 {
+		phenomenonTime = phenomenonTime[i+1:]
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, phenomenonTime)
+	return t, err == nil
+}
@@ -0,0 +1,65 @@
+package module
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSuppressionPolicySuppress(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	last := func(result interface{}, ago time.Duration) *postState {
+		return newPostState("http://h/", "1", result, time.Time{}, now.Add(-ago))
+	}
+
+	tests := []struct {
+		name            string
+		policy          SuppressionPolicy
+		last            *postState
+		result          interface{}
+		allowDuplicates bool
+		suppress        bool
+	}{
+		{"first result", SuppressionPolicy{Deadband: 1}, nil, 20.0, false, false},
+		{"duplicate allowed", SuppressionPolicy{}, last(20.0, time.Minute), 20.0, true, false},
+		{"duplicate not allowed", SuppressionPolicy{}, last(20.0, time.Minute), 20.0, false, true},
+		{"duplicate string not allowed", SuppressionPolicy{}, last("open", time.Minute), "open", false, true},
+		{"changed string", SuppressionPolicy{Deadband: 1}, last("open", time.Minute), "closed", true, false},
+		{"within deadband", SuppressionPolicy{Deadband: 0.5}, last(20.0, time.Minute), 20.3, true, true},
+		{"on deadband", SuppressionPolicy{Deadband: 0.5}, last(20.0, time.Minute), 20.5, true, false},
+		{"outside deadband", SuppressionPolicy{Deadband: 0.5}, last(20.0, time.Minute), 19.4, true, false},
+		{"within deadband percent", SuppressionPolicy{DeadbandPercent: 10}, last(200.0, time.Minute), 215.0, true, true},
+		{"outside deadband percent", SuppressionPolicy{DeadbandPercent: 10}, last(200.0, time.Minute), 225.0, true, false},
+		{"deadband percent of negative value", SuppressionPolicy{DeadbandPercent: 10}, last(-200.0, time.Minute), -190.0, true, true},
+		{"within min interval", SuppressionPolicy{MinIntervalSeconds: 300}, last(20.0, time.Minute), 30.0, true, true},
+		{"after min interval", SuppressionPolicy{MinIntervalSeconds: 300}, last(20.0, time.Minute*10), 30.0, true, false},
+		{"heartbeat overrides deadband", SuppressionPolicy{Deadband: 5, HeartbeatSeconds: 600}, last(20.0, time.Minute*10), 20.0, false, false},
+		{"heartbeat not reached", SuppressionPolicy{Deadband: 5, HeartbeatSeconds: 600}, last(20.0, time.Minute*5), 21.0, true, true},
+		{"number after string", SuppressionPolicy{Deadband: 5}, last("open", time.Minute), 1.0, true, false},
+	}
+
+	for _, test := range tests {
+		if got := test.policy.suppress(test.last, test.result, test.allowDuplicates, now); got != test.suppress {
+			t.Errorf("%s: expected suppress %v, got %v", test.name, test.suppress, got)
+		}
+	}
+}
+
+func TestSuppressionPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy SuppressionPolicy
+		valid  bool
+	}{
+		{SuppressionPolicy{}, true},
+		{SuppressionPolicy{Deadband: 1, DeadbandPercent: 5, MinIntervalSeconds: 60, HeartbeatSeconds: 3600}, true},
+		{SuppressionPolicy{Deadband: -1}, false},
+		{SuppressionPolicy{DeadbandPercent: -1}, false},
+		{SuppressionPolicy{MinIntervalSeconds: -1}, false},
+		{SuppressionPolicy{HeartbeatSeconds: -1}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.policy.validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid %v, got %v", test.policy, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// unit is a unit of measurement which can be converted to the base unit of its
+// dimension using base = value * scale + offset
+type unit struct {
+	dimension string
+	scale     float64
+	offset    float64
+}
+
+// units holds the units which can be used in a unit conversion
+var units = map[string]unit{
+	// temperature, base kelvin
+	"K":  {"temperature", 1, 0},
+	"C":  {"temperature", 1, 273.15},
+	"°C": {"temperature", 1, 273.15},
+	"F":  {"temperature", 5.0 / 9.0, 273.15 - 32*5.0/9.0},
+	"°F": {"temperature", 5.0 / 9.0, 273.15 - 32*5.0/9.0},
+	// pressure, base pascal
+	"Pa":   {"pressure", 1, 0},
+	"hPa":  {"pressure", 100, 0},
+	"mbar": {"pressure", 100, 0},
+	"kPa":  {"pressure", 1000, 0},
+	"bar":  {"pressure", 100000, 0},
+	"inHg": {"pressure", 3386.389, 0},
+	"mmHg": {"pressure", 133.322387415, 0},
+	// speed, base meter per second
+	"m/s":  {"speed", 1, 0},
+	"km/h": {"speed", 1 / 3.6, 0},
+	"mph":  {"speed", 0.44704, 0},
+	"kn":   {"speed", 1852.0 / 3600.0, 0},
+	// length, base meter
+	"m":  {"length", 1, 0},
+	"cm": {"length", 0.01, 0},
+	"mm": {"length", 0.001, 0},
+	"in": {"length", 0.0254, 0},
+	"ft": {"length", 0.3048, 0},
+	// ratio, base fraction
+	"fraction": {"ratio", 1, 0},
+	"%":        {"ratio", 0.01, 0},
+}
+
+// Conversion converts a value from one unit to another unit of the same dimension
+type Conversion struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Transform describes the changes made to the result of an observation before it is send, the
+// unit conversion is applied first followed by scale, offset, clamping to min and max and rounding
+type Transform struct {
+	Convert *Conversion `json:"convert,omitempty"`
+	Scale   *float64    `json:"scale,omitempty"`
+	Offset  *float64    `json:"offset,omitempty"`
+	Min     *float64    `json:"min,omitempty"`
+	Max     *float64    `json:"max,omitempty"`
+	Round   *int        `json:"round,omitempty"`
+}
+
+// validate checks if the units of the conversion are known and can be converted
+func (t *Transform) validate() error {
+	if t.Convert == nil {
+		return nil
+	}
+
+	from, ok := units[t.Convert.From]
+	if !ok {
+		return fmt.Errorf("unknown unit %s", t.Convert.From)
+	}
+
+	to, ok := units[t.Convert.To]
+	if !ok {
+		return fmt.Errorf("unknown unit %s", t.Convert.To)
+	}
+
+	if from.dimension != to.dimension {
+		return fmt.Errorf("unable to convert %s to %s", t.Convert.From, t.Convert.To)
+	}
+
+	return nil
+}
+
+// Apply returns the transformed result, results which are not a number are returned unchanged.
+// Without conversion, scale, offset or rounding a result within min and max is returned as is
+// so integers and numeric strings keep their type
+func (t *Transform) Apply(result interface{}) interface{} {
+	v, ok := toFloat(result)
+	if !ok {
+		return result
+	}
+
+	changed := t.Convert != nil || t.Scale != nil || t.Offset != nil || t.Round != nil
+	if t.Convert != nil {
+		from, to := units[t.Convert.From], units[t.Convert.To]
+		v = (v*from.scale + from.offset - to.offset) / to.scale
+	}
+
+	if t.Scale != nil {
+		v = v * *t.Scale
+	}
+
+	if t.Offset != nil {
+		v = v + *t.Offset
+	}
+
+	if t.Min != nil && v < *t.Min {
+		v, changed = *t.Min, true
+	}
+
+	if t.Max != nil && v > *t.Max {
+		v, changed = *t.Max, true
+	}
+
+	if t.Round != nil {
+		p := math.Pow(10, float64(*t.Round))
+		v = math.Round(v*p) / p
+	}
+
+	if !changed {
+		return result
+	}
+
+	return v
+}
+
+// toFloat converts a numeric result to a float64
+func toFloat(result interface{}) (float64, bool) {
+	switch v := result.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
@@ -0,0 +1,80 @@
+package module
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestTransformApply(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	i := func(v int) *int { return &v }
+
+	tests := []struct {
+		name      string
+		transform Transform
+		result    interface{}
+		expected  interface{}
+	}{
+		{"no transform", Transform{}, 21.5, 21.5},
+		{"not a number", Transform{Scale: f(2)}, "open", "open"},
+		{"boolean", Transform{Scale: f(2)}, true, true},
+		{"numeric string", Transform{Scale: f(2)}, "1.5", 3.0},
+		{"json number", Transform{Offset: f(1)}, json.Number("2"), 3.0},
+		{"int", Transform{Scale: f(0.5)}, 3, 1.5},
+		{"scale and offset", Transform{Scale: f(10), Offset: f(-5)}, 1.0, 5.0},
+		{"celsius to fahrenheit", Transform{Convert: &Conversion{From: "C", To: "F"}}, 100.0, 212.0},
+		{"fahrenheit to kelvin", Transform{Convert: &Conversion{From: "°F", To: "K"}}, 32.0, 273.15},
+		{"hPa to bar", Transform{Convert: &Conversion{From: "hPa", To: "bar"}}, 1013.0, 1.013},
+		{"km/h to m/s", Transform{Convert: &Conversion{From: "km/h", To: "m/s"}}, 36.0, 10.0},
+		{"percent to fraction", Transform{Convert: &Conversion{From: "%", To: "fraction"}}, 45.0, 0.45},
+		{"conversion before scale", Transform{Convert: &Conversion{From: "cm", To: "m"}, Scale: f(2)}, 50.0, 1.0},
+		{"clamp min", Transform{Min: f(0)}, -3.0, 0.0},
+		{"clamp max", Transform{Max: f(100)}, 120.0, 100.0},
+		{"round", Transform{Round: i(1)}, 21.46, 21.5},
+		{"round to integer", Transform{Round: i(0)}, 21.5, 22.0},
+		{"round after clamp", Transform{Max: f(10.555), Round: i(2)}, 20.0, 10.56},
+		{"int without transform", Transform{}, 3, 3},
+		{"numeric string without transform", Transform{}, "1.5", "1.5"},
+		{"int within clamp", Transform{Min: f(0), Max: f(10)}, 3, 3},
+		{"json number within clamp", Transform{Min: f(0)}, json.Number("2"), json.Number("2")},
+		{"int clamped", Transform{Max: f(2)}, 3, 2.0},
+	}
+
+	for _, test := range tests {
+		got := test.transform.Apply(test.result)
+		expected, isNumber := test.expected.(float64)
+		if !isNumber {
+			if got != test.expected {
+				t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+			}
+			continue
+		}
+
+		v, ok := got.(float64)
+		if !ok || math.Abs(v-expected) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestTransformValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		convert *Conversion
+		valid   bool
+	}{
+		{"no conversion", nil, true},
+		{"same dimension", &Conversion{From: "C", To: "F"}, true},
+		{"unknown from", &Conversion{From: "X", To: "F"}, false},
+		{"unknown to", &Conversion{From: "C", To: "X"}, false},
+		{"different dimension", &Conversion{From: "C", To: "m"}, false},
+	}
+
+	for _, test := range tests {
+		transform := Transform{Convert: test.convert}
+		if err := transform.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
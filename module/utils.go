@@ -2,8 +2,10 @@ package module
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/url"
@@ -11,7 +13,10 @@ import (
 	"time"
 )
 
-var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+var (
+	letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	httpClient  = &http.Client{Timeout: 30 * time.Second}
+)
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
@@ -27,14 +32,33 @@ func RandomID(n int) string {
 	return string(b)
 }
 
+// HTTPClient returns the HTTP client configured in the connector, modules should use this
+// client for requests to vendor APIs
+func HTTPClient() *http.Client {
+	return httpClient
+}
+
+// SetHTTPClient sets the HTTP client returned by HTTPClient
+func SetHTTPClient(client *http.Client) {
+	httpClient = client
+}
+
 // GetJSON is used to fetch data for a given url and getting parsed into a given interface
 func GetJSON(urlStr string, target interface{}) error {
-	req, _ := http.NewRequest("GET", URLEncoded(urlStr), nil)
+	return GetJSONWithContext(context.Background(), urlStr, target)
+}
+
+// GetJSONWithContext fetches data like GetJSON, the request is cancelled when ctx is done
+func GetJSONWithContext(ctx context.Context, urlStr string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", URLEncoded(urlStr), nil)
+	if err != nil {
+		return err
+	}
+
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
 
-	resp, err := client.Do(req)
+	resp, err := HTTPClient().Do(req)
 	if err != nil {
 		return err
 	}
@@ -44,19 +68,25 @@ func GetJSON(urlStr string, target interface{}) error {
 
 // PostJSON is used to post data as JSON to a server
 func PostJSON(urlStr string, data interface{}, expectedStatus int) (*http.Response, error) {
+	return PostJSONWithClient(HTTPClient(), urlStr, data, expectedStatus)
+}
+
+// PostJSONWithClient is used to post data as JSON to a server using the given client
+func PostJSONWithClient(client *http.Client, urlStr string, data interface{}, expectedStatus int) (*http.Response, error) {
 	b, _ := json.Marshal(data)
 	req, _ := http.NewRequest("POST", urlStr, bytes.NewBuffer(b))
 	req.Header.Set("Content-Type", "application/json")
-	req.Close = true
-
-	client := &http.Client{}
 
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
+	// read the complete body so the connection can be reused, the body is kept
+	// in memory so it can still be read from the returned response
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
 
 	if expectedStatus != resp.StatusCode {
 		return resp, fmt.Errorf("Unexpected StatusCode, expected %v got %v", expectedStatus, resp.StatusCode)
@@ -65,6 +95,17 @@ func PostJSON(urlStr string, data interface{}, expectedStatus int) (*http.Respon
 	return resp, nil
 }
 
+// RedactURL removes credentials from an url so it can be shown to users
+func RedactURL(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil || u.User == nil {
+		return urlStr
+	}
+
+	u.User = nil
+	return u.String()
+}
+
 // URLEncoded encodes a string like Javascript's encodeURIComponent()
 func URLEncoded(str string) string {
 	str = strings.Replace(str, "'", "%27", -1)
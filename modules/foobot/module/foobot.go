@@ -1,7 +1,9 @@
 package foobot
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -11,13 +13,18 @@ import (
 )
 
 var (
-	minFetchInterval = 500 // 200 req day = 432 seconds
+	minFetchInterval   = 500 // 200 req day = 432 seconds
+	errIncorrectAPIKey = errors.New("incorrect api key")
 )
 
 // Setup initialised the module by setting some default values
 func (m *Module) Setup() error {
 	m.ModuleName = "Foobot"
 	m.ModuleDescription = "Publish Foobot sensor readings to a SensorThings server"
+	m.MinFetchInterval = minFetchInterval
+	m.Fetcher = m
+	m.Backfiller = m
+	m.MappingKeys = module.MappingKeys{Device: "uuid", Field: "sensor"}
 	m.Endpoints = m.getEndpoints()
 
 	m.settings = Settings{}
@@ -33,94 +40,107 @@ func (m *Module) Setup() error {
 	return nil
 }
 
-// Start receiving Netatmo readings and publish it to a SensorThings server
-func (m *Module) Start(initStartup bool) error {
-	interval := m.settings.FetchInterval
-	if interval == 0 || interval < minFetchInterval {
-		interval = minFetchInterval
-	}
-
-	// Get some readings at start
-	go m.getReadings()
-
-	m.ticker = time.NewTicker(time.Second * time.Duration(interval))
-	go func() {
-		for range m.ticker.C {
-			m.getReadings()
+// Fetch requests the latest readings and publishes them, it is called by the module schedule
+func (m *Module) Fetch(ctx context.Context) {
+	for _, uuid := range m.MappedDevices() {
+		if ctx.Err() != nil {
+			return
 		}
-	}()
 
-	return nil
-}
+		fj, err := m.requestDatapoints(ctx, uuid, "0/last/0")
+		if err != nil {
+			// by setting fatal to true, module will stop running
+			m.SendError(err, err == errIncorrectAPIKey)
+			return
+		}
 
-// Stop receiving Netatmo readings
-func (m *Module) Stop() {
-	if m.ticker != nil {
-		m.ticker.Stop()
+		m.SendReadings(getReadings(uuid, fj))
 	}
 }
 
-func (m *Module) getReadings() {
-	for _, ma := range m.settings.Mappings {
-		url := fmt.Sprintf("https://api.foobot.io/v2/device/%s/datapoint/0/last/0/", ma.UUID)
-
-		foobotClient := http.Client{
-			Timeout: time.Second * 10,
+// Backfill requests the readings of all devices between the from and to of the backfill
+func (m *Module) Backfill(ctx context.Context, b *module.Backfill) error {
+	devices := m.MappedDevices()
+	for i, uuid := range devices {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 
-		req, _ := http.NewRequest(http.MethodGet, url, nil)
-		req.Header.Set("X-API-KEY-TOKEN", m.settings.SecretKey)
-
-		res, err := foobotClient.Do(req)
+		fj, err := m.requestDatapoints(ctx, uuid, fmt.Sprintf("%v/%v/0", b.From.Unix(), b.To.Unix()))
 		if err != nil {
-			m.SendError(err, false)
-			return
+			return err
 		}
 
-		if res == nil {
-			m.SendError(fmt.Errorf("response is nil"), false)
-			return
-		}
+		b.SendReadings(getReadings(uuid, fj))
+		b.Progress(i+1, len(devices))
+	}
 
-		if res.StatusCode == 401 {
-			// by setting fatal to true, module will stop running
-			m.SendError(fmt.Errorf("incorrect api key"), true)
-			return
-		}
+	return nil
+}
 
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			m.SendError(err, false)
-			return
-		}
+// requestDatapoints requests the datapoints of a device, period is the start, end and averageBy part of the url
+func (m *Module) requestDatapoints(ctx context.Context, uuid, period string) (FoobotJSON, error) {
+	fj := FoobotJSON{}
+	url := fmt.Sprintf("https://api.foobot.io/v2/device/%s/datapoint/%s/", uuid, period)
 
-		fj := FoobotJSON{}
-		err = json.Unmarshal(body, &fj)
-		if err != nil {
-			m.SendError(err, false)
-			return
-		}
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req = req.WithContext(ctx)
+	req.Header.Set("X-API-KEY-TOKEN", m.settings.SecretKey)
+
+	res, err := module.HTTPClient().Do(req)
+	if err != nil {
+		return fj, err
+	}
+
+	if res == nil {
+		return fj, fmt.Errorf("response is nil")
+	}
+
+	defer res.Body.Close()
 
-		m.handleReadings(ma, fj)
+	if res.StatusCode == 401 {
+		return fj, errIncorrectAPIKey
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fj, err
 	}
+
+	err = json.Unmarshal(body, &fj)
+	return fj, err
 }
 
-func (m *Module) handleReadings(ma Mapping, response FoobotJSON) {
-	kvp := make(map[string]float64, 0)
+// getReadings returns the readings of every datapoint, the time of a datapoint is read from
+// the time sensor when available
+func getReadings(uuid string, response FoobotJSON) []module.Reading {
+	readings := make([]module.Reading, 0)
+	timeIndex := -1
 	for i, s := range response.Sensors {
-		kvp[s] = response.Datapoints[0][i]
+		if s == "time" {
+			timeIndex = i
+		}
 	}
 
-	for k, v := range kvp {
-		for _, s := range ma.Streams {
-			if s.Sensor == k {
-				obs := module.Observation{
-					Result:         v,
-					PhenomenonTime: time.Unix(int64(response.End), 0).Format(time.RFC3339Nano),
-				}
+	for _, datapoint := range response.Datapoints {
+		t := response.End
+		if timeIndex >= 0 && timeIndex < len(datapoint) {
+			t = int64(datapoint[timeIndex])
+		}
 
-				m.SendObservation(ma.Server, s.StreamID, obs)
+		for i, sensor := range response.Sensors {
+			if i >= len(datapoint) {
+				break
 			}
+
+			readings = append(readings, module.Reading{
+				DeviceKey: uuid,
+				FieldKey:  sensor,
+				Value:     datapoint[i],
+				Time:      time.Unix(t, 0),
+			})
 		}
 	}
+
+	return readings
 }
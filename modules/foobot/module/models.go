@@ -1,8 +1,6 @@
 package foobot
 
 import (
-	"time"
-
 	"github.com/gost/sensorthings-connector/module"
 )
 
@@ -11,28 +9,11 @@ import (
 type Module struct {
 	module.ConnectorModuleBase
 	settings Settings
-	ticker   *time.Ticker
 }
 
 // Settings contains information on Netatmo login and sensor reading to datastream mappings
 type Settings struct {
-	SecretKey     string    `json:"secretKey"`
-	FetchInterval int       `json:"fetchIntervalSeconds"`
-	Mappings      []Mapping `json:"mappings"`
-}
-
-// Mapping contains information about the link between the netatmo stations, sensors and datastreams
-type Mapping struct {
-	UUID    string   `json:"uuid"`
-	Name    string   `json:"name"`
-	Server  string   `json:"server"`
-	Streams []Stream `json:"streams"`
-}
-
-// Stream Netatmo type to SensorThings stream
-type Stream struct {
-	Sensor   string `json:"sensor"`
-	StreamID string `json:"streamId"`
+	SecretKey string `json:"secretKey"`
 }
 
 // FoobotJSON response from Foobot api
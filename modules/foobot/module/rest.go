@@ -30,6 +30,9 @@ func (m *Module) getSettingsHandler(w http.ResponseWriter, r *http.Request, ps h
 	*c = m.settings
 	c.SecretKey = ""
 
-	b, _ := json.MarshalIndent(c, "", "   ")
+	b, _ := json.MarshalIndent(struct {
+		Settings
+		Mappings json.RawMessage `json:"mappings"`
+	}{*c, m.GetMappings()}, "", "   ")
 	w.Write(b)
 }
@@ -1,11 +1,13 @@
 package homecoach
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 
+	"github.com/gost/sensorthings-connector/module"
 	"golang.org/x/oauth2"
 )
 
@@ -47,11 +49,12 @@ func NewClient(config Config) (*Client, error) {
 		},
 	}
 
-	token, err := oauth.PasswordCredentialsToken(oauth2.NoContext, config.Username, config.Password)
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, module.HTTPClient())
+	token, err := oauth.PasswordCredentialsToken(ctx, config.Username, config.Password)
 
 	return &Client{
 		oauth:      oauth,
-		httpClient: oauth.Client(oauth2.NoContext, token),
+		httpClient: oauth.Client(ctx, token),
 	}, err
 }
 
@@ -133,11 +136,11 @@ type Administrative struct {
 }
 
 // send http GET request
-func (c *Client) doHTTPGet(url string, data url.Values) (*http.Response, error) {
+func (c *Client) doHTTPGet(ctx context.Context, url string, data url.Values) (*http.Response, error) {
 	if data != nil {
 		url = url + "?" + data.Encode()
 	}
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -172,9 +175,10 @@ func processHTTPResponse(resp *http.Response, err error, holder interface{}) err
 	return json.NewDecoder(resp.Body).Decode(holder)
 }
 
-// GetStations returns the list of stations owned by the user, and their modules
-func (c *Client) Read() (*Response, error) {
-	resp, err := c.doHTTPGet(dataURL, nil)
+// GetStations returns the list of stations owned by the user, and their modules, the
+// request is cancelled when ctx is done
+func (c *Client) Read(ctx context.Context) (*Response, error) {
+	resp, err := c.doHTTPGet(ctx, dataURL, nil)
 	if err != nil {
 		return nil, err
 	}
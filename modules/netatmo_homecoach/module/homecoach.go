@@ -1,6 +1,7 @@
 package homecoach
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -15,6 +16,9 @@ var (
 func (m *Module) Setup() error {
 	m.ModuleName = "Netatmo Homecoach"
 	m.ModuleDescription = "Publish Netatmo Homecoach readings to a SensorThings server"
+	m.MinFetchInterval = minFetchInterval
+	m.Fetcher = m
+	m.MappingKeys = module.MappingKeys{Device: "moduleId", Field: "type"}
 	m.Endpoints = m.getEndpoints()
 
 	m.settings = Settings{}
@@ -41,63 +45,37 @@ func (m *Module) Setup() error {
 	return nil
 }
 
-// Start receiving Netatmo readings and publish it to a SensorThings server
-func (m *Module) Start(initStartup bool) error {
-	interval := m.settings.FetchInterval
-	if interval == 0 || interval < minFetchInterval {
-		interval = minFetchInterval
+// Fetch requests the latest readings and publishes them, it is called by the module schedule
+func (m *Module) Fetch(ctx context.Context) {
+	r, err := m.client.Read(ctx)
+	if ctx.Err() != nil {
+		return
 	}
 
-	// Get some readings at start
-	go m.getReadings()
-
-	m.ticker = time.NewTicker(time.Second * time.Duration(interval))
-	go func() {
-		for range m.ticker.C {
-			m.getReadings()
-		}
-	}()
-
-	return nil
-}
-
-// Stop receiving Netatmo readings
-func (m *Module) Stop() {
-	if m.ticker != nil {
-		m.ticker.Stop()
-	}
-}
-
-func (m *Module) getReadings() {
-	r, err := m.client.Read()
 	if err != nil {
 		m.SendError(fmt.Errorf("unable to get netatmo homecoach sensor values: %v", err), false)
 	} else {
-		m.handleReadings(r)
+		m.SendReadings(getReadings(r))
 	}
 }
 
-// ToDo: Lesser for loops -> create mappings?
-func (m *Module) handleReadings(response *Response) {
+// getReadings returns the readings of the devices in the response, the device id is used as
+// device and the dashboard data name as field
+func getReadings(response *Response) []module.Reading {
+	readings := make([]module.Reading, 0)
 	for _, mod := range response.Body.Devices {
-		for _, mapping := range m.settings.Mappings {
-			if mapping.ModuleID == mod.ID {
-				data := dashboardDataToMap(mod.DashboardData)
-				for dataType, value := range data {
-					for _, s := range mapping.Streams {
-						if s.Type == dataType {
-							obs := module.Observation{
-								Result:         value,
-								PhenomenonTime: time.Unix(int64(mod.DashboardData.TimeUTC), 0).Format(time.RFC3339Nano),
-							}
-
-							m.SendObservation(mapping.Server, s.StreamID, obs)
-						}
-					}
-				}
-			}
+		t := time.Unix(int64(mod.DashboardData.TimeUTC), 0)
+		for dataType, value := range dashboardDataToMap(mod.DashboardData) {
+			readings = append(readings, module.Reading{
+				DeviceKey: mod.ID,
+				FieldKey:  dataType,
+				Value:     value,
+				Time:      t,
+			})
 		}
 	}
+
+	return readings
 }
 
 func dashboardDataToMap(data DashboardData) map[string]interface{} {
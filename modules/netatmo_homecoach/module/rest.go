@@ -32,6 +32,9 @@ func (m *Module) getSettingsHandler(w http.ResponseWriter, r *http.Request, ps h
 	c.ClientSecret = ""
 	c.Password = ""
 
-	b, _ := json.MarshalIndent(c, "", "   ")
+	b, _ := json.MarshalIndent(struct {
+		Settings
+		Mappings json.RawMessage `json:"mappings"`
+	}{*c, m.GetMappings()}, "", "   ")
 	w.Write(b)
 }
@@ -1,6 +1,7 @@
 package weather
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -16,6 +17,9 @@ var (
 func (m *Module) Setup() error {
 	m.ModuleName = "Netatmo Weather"
 	m.ModuleDescription = "Publish Netatmo Weather readings to a SensorThings server"
+	m.MinFetchInterval = minFetchInterval
+	m.Fetcher = m
+	m.MappingKeys = module.MappingKeys{Device: "moduleId", Field: "type"}
 	m.Endpoints = m.getEndpoints()
 
 	m.settings = Settings{}
@@ -28,6 +32,7 @@ func (m *Module) Setup() error {
 		m.SendError(fmt.Errorf("missing config parameters"), true)
 	}
 
+	// netatmo-api-go creates its own HTTP client, the http settings of the connector are not applied
 	m.client, err = netatmo.NewClient(netatmo.Config{
 		ClientID:     m.settings.ClientID,
 		ClientSecret: m.settings.ClientSecret,
@@ -42,63 +47,52 @@ func (m *Module) Setup() error {
 	return nil
 }
 
-// Start receiving Netatmo readings and publish it to a SensorThings server
-func (m *Module) Start(initStartup bool) error {
-	interval := m.settings.FetchInterval
-	if interval == 0 || interval < minFetchInterval {
-		interval = minFetchInterval
+// Fetch requests the latest readings and publishes them, it is called by the module schedule.
+// netatmo-api-go does not accept a context, Fetch returns when the module is stopped and the
+// readings of the running request are not send
+func (m *Module) Fetch(ctx context.Context) {
+	type result struct {
+		dc  *netatmo.DeviceCollection
+		err error
 	}
 
-	// Get some readings at start
-	go m.getReadings()
-
-	m.ticker = time.NewTicker(time.Second * time.Duration(interval))
+	done := make(chan result, 1)
 	go func() {
-		for range m.ticker.C {
-			m.getReadings()
-		}
+		dc, err := m.client.Read()
+		done <- result{dc, err}
 	}()
 
-	return nil
-}
-
-// Stop receiving Netatmo readings
-func (m *Module) Stop() {
-	if m.ticker != nil {
-		m.ticker.Stop()
+	var r result
+	select {
+	case <-ctx.Done():
+		return
+	case r = <-done:
 	}
-}
 
-func (m *Module) getReadings() {
-	dc, err := m.client.Read()
-	if err != nil {
-		m.SendError(fmt.Errorf("unable to get netatmo sensor values: %v", err), false)
+	if r.err != nil {
+		m.SendError(fmt.Errorf("unable to get netatmo sensor values: %v", r.err), false)
 	} else {
-		for _, station := range dc.Stations() {
-			go m.handleReadings(station.Modules())
+		for _, station := range r.dc.Stations() {
+			m.SendReadings(getReadings(station.Modules()))
 		}
 	}
 }
 
-// ToDo: Lesser for loops -> create mappings?
-func (m *Module) handleReadings(modules []*netatmo.Device) {
+// getReadings returns the readings of the given modules, the module id is used as device and
+// the data type as field
+func getReadings(modules []*netatmo.Device) []module.Reading {
+	readings := make([]module.Reading, 0)
 	for _, mod := range modules {
-		for _, mapping := range m.settings.Mappings {
-			if mapping.ModuleID == mod.ID {
-				ts, data := mod.Data()
-				for dataType, value := range data {
-					for _, s := range mapping.Streams {
-						if s.Type == dataType {
-							obs := module.Observation{
-								Result:         value,
-								PhenomenonTime: time.Unix(int64(ts), 0).Format(time.RFC3339Nano),
-							}
-
-							m.SendObservation(mapping.Server, s.StreamID, obs)
-						}
-					}
-				}
-			}
+		ts, data := mod.Data()
+		for dataType, value := range data {
+			readings = append(readings, module.Reading{
+				DeviceKey: mod.ID,
+				FieldKey:  dataType,
+				Value:     value,
+				Time:      time.Unix(int64(ts), 0),
+			})
 		}
 	}
+
+	return readings
 }
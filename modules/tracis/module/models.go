@@ -1,8 +1,6 @@
 package tracis
 
 import (
-	"time"
-
 	"github.com/gost/sensorthings-connector/module"
 )
 
@@ -11,29 +9,12 @@ import (
 type Module struct {
 	module.ConnectorModuleBase
 	settings Settings
-	ticker   *time.Ticker
 }
 
 // Settings contains information on Netatmo login and sensor reading to datastream mappings
 type Settings struct {
-	APIKey        string    `json:"apiKey"`
-	TracisHost    string    `json:"tracisHost"`
-	FetchInterval int       `json:"fetchIntervalSeconds"`
-	Mappings      []Mapping `json:"mappings"`
-}
-
-// Mapping contains information about the link between the netatmo stations, sensors and datastreams
-type Mapping struct {
-	Name        string   `json:"name"`
-	Server      string   `json:"server"`
-	EquipmentID string   `json:"equipmentId"`
-	Streams     []Stream `json:"streams"`
-}
-
-// Stream Netatmo type to SensorThings stream
-type Stream struct {
-	ChannelNumber string `json:"channelNumber"`
-	StreamID      string `json:"streamId"`
+	APIKey     string `json:"apiKey"`
+	TracisHost string `json:"tracisHost"`
 }
 
 type Equipment struct {
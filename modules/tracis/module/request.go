@@ -1,21 +1,25 @@
 package tracis
 
 import (
+	"context"
 	"fmt"
+	"net/url"
+	"time"
 
 	"github.com/gost/sensorthings-connector/module"
 )
 
 const (
-	basePath    = "/api/equipmentdata"
-	getDataPath = "/getdata"
+	basePath           = "/api/equipmentdata"
+	getDataPath        = "/getdata"
+	getDataInRangePath = "/getdataindaterange"
 )
 
-// GetData retrieves the measurement from tracis for given equipment id
-func GetData(host, apiKey, equipmentID string, count int) ([]Equipment, error) {
+// GetData retrieves the measurement from tracis for given equipment id, the request is cancelled when ctx is done
+func GetData(ctx context.Context, host, apiKey, equipmentID string, count int) ([]Equipment, error) {
 	var equipmentItems []Equipment
 	url := constructURL(host, getDataPath, apiKey, equipmentID, fmt.Sprintf("&count=%v", count))
-	err := module.GetJSON(url, &equipmentItems)
+	err := module.GetJSONWithContext(ctx, url, &equipmentItems)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to retrieve data from tracis: %v", err)
 	}
@@ -25,8 +29,20 @@ func GetData(host, apiKey, equipmentID string, count int) ([]Equipment, error) {
 	return equipmentItems, nil
 }
 
+// GetDataInDateRange retrieves the measurements from tracis for given equipment id between from and to,
+// the request is cancelled when ctx is done
+func GetDataInDateRange(ctx context.Context, host, apiKey, equipmentID string, from, to time.Time) ([]Equipment, error) {
+	var equipmentItems []Equipment
+	trail := fmt.Sprintf("&startdate=%s&enddate=%s", url.QueryEscape(from.In(location).Format(TRACISTIME)), url.QueryEscape(to.In(location).Format(TRACISTIME)))
+	url := constructURL(host, getDataInRangePath, apiKey, equipmentID, trail)
+	err := module.GetJSONWithContext(ctx, url, &equipmentItems)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve data in date range from tracis: %v", err)
+	}
+	return equipmentItems, nil
+}
+
 // ToDo: GetDataByDate
-// ToDo: GetDataInDateRange
 func constructURL(host, methodPath, apiKey, equipmentID, trail string) string {
 	return fmt.Sprintf("%s%s%s?apikey=%s&equipmentid=%s%s", host, basePath, methodPath, apiKey, equipmentID, trail)
 }
@@ -1,6 +1,7 @@
 package tracis
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"time"
@@ -17,8 +18,8 @@ const (
 
 var (
 	minFetchInterval = 60
+	backfillDays     = 1
 	location         *time.Location
-	equipmentIds     []string
 )
 
 // Setup initialised the module by setting some default values
@@ -26,6 +27,10 @@ func (m *Module) Setup() error {
 	location, _ = time.LoadLocation("Europe/Amsterdam")
 	m.ModuleName = "Tracis"
 	m.ModuleDescription = "Publish Tracis readings to a SensorThings server"
+	m.MinFetchInterval = minFetchInterval
+	m.Fetcher = m
+	m.Backfiller = m
+	m.MappingKeys = module.MappingKeys{Device: "equipmentId", Field: "channelNumber"}
 	m.settings = Settings{}
 
 	err := m.GetSettings(&m.settings)
@@ -37,88 +42,85 @@ func (m *Module) Setup() error {
 		m.SendError(fmt.Errorf("missing config parameters"), true)
 	}
 
-	equipmentIds = make([]string, 0)
-	for _, mapping := range m.settings.Mappings {
-		if !stringInSlice(mapping.EquipmentID, equipmentIds) {
-			equipmentIds = append(equipmentIds, mapping.EquipmentID)
-		}
-	}
-
 	return nil
 }
 
-func stringInSlice(str string, list []string) bool {
-	for _, v := range list {
-		if v == str {
-			return true
+// Fetch requests the latest readings for all equipment, it is called by the module schedule
+func (m *Module) Fetch(ctx context.Context) {
+	for _, e := range m.MappedDevices() {
+		if ctx.Err() != nil {
+			return
 		}
+
+		m.requestAPI(ctx, e)
 	}
-	return false
 }
 
-// Start receiving Netatmo readings and publish it to a SensorThings server
-func (m *Module) Start(initStartup bool) error {
-	interval := m.settings.FetchInterval
-	if interval == 0 || interval < minFetchInterval {
-		interval = minFetchInterval
-	}
+// Backfill requests the readings for all equipment between the from and to of the backfill,
+// the time range is requested per day
+func (m *Module) Backfill(ctx context.Context, b *module.Backfill) error {
+	step := time.Hour * 24 * time.Duration(backfillDays)
+	steps := int((b.To.Sub(b.From) + step - 1) / step)
+	equipmentIds := m.MappedDevices()
+	total := steps * len(equipmentIds)
+	done := 0
 
-	// Get some readings at start
 	for _, e := range equipmentIds {
-		m.requestAPI(e)
-	}
+		for from := b.From; from.Before(b.To); from = from.Add(step) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 
-	m.ticker = time.NewTicker(time.Second * time.Duration(interval))
-	go func() {
-		for range m.ticker.C {
-			for _, e := range equipmentIds {
-				m.requestAPI(e)
+			to := from.Add(step)
+			if to.After(b.To) {
+				to = b.To
 			}
-		}
-	}()
 
-	return nil
-}
+			equipmentItems, err := GetDataInDateRange(ctx, m.settings.TracisHost, m.settings.APIKey, e, from, to)
+			if err != nil {
+				return err
+			}
 
-// Stop receiving Netatmo readings
-func (m *Module) Stop() {
-	if m.ticker != nil {
-		m.ticker.Stop()
+			b.SendReadings(getReadings(e, equipmentItems))
+			done++
+			b.Progress(done, total)
+		}
 	}
+
+	return nil
 }
 
-func (m *Module) requestAPI(equipmentID string) {
+func (m *Module) requestAPI(ctx context.Context, equipmentID string) {
 	// GetData from tracis
-	equipmentItems, err := GetData(m.settings.TracisHost, m.settings.APIKey, equipmentID, 1)
+	equipmentItems, err := GetData(ctx, m.settings.TracisHost, m.settings.APIKey, equipmentID, 1)
 	if err != nil {
+		// the request was cancelled because the module is stopped
+		if ctx.Err() != nil {
+			return
+		}
+
 		m.SendError(err, false)
 		return
 	}
 
 	// There should only be one since we requested &count=1
-	for _, item := range equipmentItems {
-		if item.Sensors == nil {
-			continue
-		}
+	m.SendReadings(getReadings(equipmentID, equipmentItems))
+}
 
-		for _, mapping := range m.settings.Mappings {
-			if mapping.EquipmentID == equipmentID {
-				for _, sensor := range item.Sensors {
-					sID := strconv.Itoa(sensor.ChannelNumber)
-					t, _ := time.ParseInLocation(TRACISTIME, fmt.Sprintf("%s.000", sensor.DateTime), location)
-					t2 := t.Format(ISO8601)
-					obs := module.Observation{
-						PhenomenonTime: t2,
-						Result:         sensor.Value,
-					}
-
-					for _, stream := range mapping.Streams {
-						if sID == stream.ChannelNumber {
-							m.SendObservation(mapping.Server, stream.StreamID, obs)
-						}
-					}
-				}
-			}
+// getReadings returns the readings of the sensors of the equipment, the channel number is used as field
+func getReadings(equipmentID string, equipmentItems []Equipment) []module.Reading {
+	readings := make([]module.Reading, 0)
+	for _, item := range equipmentItems {
+		for _, sensor := range item.Sensors {
+			t, _ := time.ParseInLocation(TRACISTIME, fmt.Sprintf("%s.000", sensor.DateTime), location)
+			readings = append(readings, module.Reading{
+				DeviceKey: equipmentID,
+				FieldKey:  strconv.Itoa(sensor.ChannelNumber),
+				Value:     sensor.Value,
+				Time:      t,
+			})
 		}
 	}
+
+	return readings
 }